@@ -0,0 +1,248 @@
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+
+    "health-check-system/pkg/config"
+    "health-check-system/pkg/database"
+    "health-check-system/pkg/secret"
+)
+
+const usage = `Usage: keytool [-keyfile path] <command>
+
+Commands:
+  init              create a new key file
+  encrypt [value]   encrypt a value (or a line from stdin) for .env
+  migrate           encrypt plaintext NIAM passwords in hc_niam_users and
+                    rewrap the others with the active key
+  rotate [-prune]   add a new active key and migrate; -prune then drops
+                    the old keys
+  prune             drop every key but the active one
+
+The key file defaults to SECRET_KEY_FILE, then config/keyring.json.
+`
+
+func main() {
+    log.SetFlags(0)
+
+    keyFile := flag.String("keyfile", "", "path of the key file")
+    flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+    flag.Parse()
+
+    if *keyFile == "" {
+        *keyFile = os.Getenv("SECRET_KEY_FILE")
+    }
+    if *keyFile == "" {
+        *keyFile = "config/keyring.json"
+    }
+
+    if flag.NArg() == 0 {
+        flag.Usage()
+        os.Exit(2)
+    }
+
+    var err error
+    switch flag.Arg(0) {
+    case "init":
+        err = initKeys(*keyFile)
+    case "encrypt":
+        err = encrypt(*keyFile, flag.Args()[1:])
+    case "migrate":
+        err = migrate(*keyFile)
+    case "rotate":
+        err = rotate(*keyFile, flag.Args()[1:])
+    case "prune":
+        err = prune(*keyFile)
+    default:
+        flag.Usage()
+        os.Exit(2)
+    }
+    if err != nil {
+        log.Fatalf("keytool %s: %v", flag.Arg(0), err)
+    }
+}
+
+func initKeys(keyFile string) error {
+    if _, err := os.Stat(keyFile); err == nil {
+        return fmt.Errorf("%s already exists, use rotate to add a key", keyFile)
+    }
+
+    keys, err := secret.NewKeyring()
+    if err != nil {
+        return err
+    }
+    if err := keys.Save(keyFile); err != nil {
+        return err
+    }
+
+    log.Printf("Created %s with key %s", keyFile, keys.Active)
+    return nil
+}
+
+func encrypt(keyFile string, args []string) error {
+    keys, err := secret.Load(keyFile)
+    if err != nil {
+        return err
+    }
+
+    value := strings.Join(args, " ")
+    if len(args) == 0 {
+        line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+        if err != nil && line == "" {
+            return fmt.Errorf("failed to read value: %w", err)
+        }
+        value = strings.TrimRight(line, "\r\n")
+    }
+
+    enc, err := keys.Encrypt(value)
+    if err != nil {
+        return err
+    }
+
+    fmt.Println(enc)
+    return nil
+}
+
+func migrate(keyFile string) error {
+    keys, err := secret.Load(keyFile)
+    if err != nil {
+        return err
+    }
+    return migrateUsers(keys)
+}
+
+func rotate(keyFile string, args []string) error {
+    fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+    prune := fs.Bool("prune", false, "drop old keys after migrating")
+    fs.Parse(args)
+
+    keys, err := secret.Load(keyFile)
+    if err != nil {
+        return err
+    }
+
+    id, err := keys.Rotate()
+    if err != nil {
+        return err
+    }
+    // Save first so the new key exists before any row uses it
+    if err := keys.Save(keyFile); err != nil {
+        return err
+    }
+    log.Printf("Added key %s", id)
+
+    if err := migrateUsers(keys); err != nil {
+        return err
+    }
+
+    log.Printf("Re-encrypt .env passwords with 'keytool encrypt', then run 'keytool prune' to drop old keys")
+    if *prune {
+        return pruneKeys(keys, keyFile)
+    }
+
+    return nil
+}
+
+func prune(keyFile string) error {
+    keys, err := secret.Load(keyFile)
+    if err != nil {
+        return err
+    }
+    return pruneKeys(keys, keyFile)
+}
+
+// pruneKeys drops the old keys, unless MITO_PROXY_PASSWORD is still
+// encrypted with one of them
+func pruneKeys(keys *secret.Keyring, keyFile string) error {
+    cfg, err := config.Load()
+    if err != nil {
+        return fmt.Errorf("failed to load config: %w", err)
+    }
+    if id, ok := secret.KeyID(cfg.MitoProxy.Password); ok && id != keys.Active {
+        return fmt.Errorf("MITO_PROXY_PASSWORD is encrypted with key %s; re-encrypt it with 'keytool encrypt' before pruning", id)
+    }
+
+    removed := keys.Prune()
+    if len(removed) == 0 {
+        log.Printf("No old keys to remove")
+        return nil
+    }
+    if err := keys.Save(keyFile); err != nil {
+        return err
+    }
+    log.Printf("Removed keys %s", strings.Join(removed, ", "))
+
+    return nil
+}
+
+// migrateUsers rewraps every NIAM password with the active key,
+// encrypting plaintext ones, in a single transaction
+func migrateUsers(keys *secret.Keyring) error {
+    cfg, err := config.Load()
+    if err != nil {
+        return fmt.Errorf("failed to load config: %w", err)
+    }
+
+    db, err := database.Connect(database.Config{
+        Host:     cfg.Database.Host,
+        Port:     cfg.Database.Port,
+        User:     cfg.Database.User,
+        Password: cfg.Database.Password,
+        Database: cfg.Database.Database,
+    })
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    rows, err := tx.Query(`SELECT user, passwd FROM hc_niam_users FOR UPDATE`)
+    if err != nil {
+        return err
+    }
+    passwords := make(map[string]string)
+    for rows.Next() {
+        var user, passwd string
+        if err := rows.Scan(&user, &passwd); err != nil {
+            rows.Close()
+            return err
+        }
+        passwords[user] = passwd
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return err
+    }
+
+    updated := 0
+    for user, passwd := range passwords {
+        enc, changed, err := keys.Rewrap(passwd)
+        if err != nil {
+            return fmt.Errorf("user %s: %w", user, err)
+        }
+        if !changed {
+            continue
+        }
+        if _, err := tx.Exec(`UPDATE hc_niam_users SET passwd = ? WHERE user = ?`, enc, user); err != nil {
+            return fmt.Errorf("user %s: %w", user, err)
+        }
+        updated++
+    }
+
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    log.Printf("Updated %d of %d NIAM passwords", updated, len(passwords))
+    return nil
+}
@@ -1,42 +1,56 @@
 package main
 
 import (
-    "fmt"
+    "context"
+    "flag"
     "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
+
+    "health-check-system/pkg/api"
+    "health-check-system/pkg/appserver"
     "health-check-system/pkg/config"
     "health-check-system/pkg/database"
-    "github.com/joho/godotenv"
+    "health-check-system/pkg/executor"
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/logging"
+    "health-check-system/pkg/profile"
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/scoring"
+    "health-check-system/pkg/secret"
+    "health-check-system/pkg/session"
+    "health-check-system/pkg/status"
+    "health-check-system/pkg/tracker"
+    "health-check-system/pkg/userpool"
 )
 
 func main() {
-    fmt.Println("===========================================")
-    fmt.Println("Health Check System - Database Test")
-    fmt.Println("===========================================")
-    fmt.Println()
-
-    // Load .env file
-    fmt.Println("Loading .env file...")
-    if err := godotenv.Load(); err != nil {
-        log.Printf("Warning: .env file not found, using environment variables")
-    } else {
-        fmt.Println("✓ .env file loaded")
-    }
-    fmt.Println()
-
-    // Load config
-    fmt.Println("Loading configuration...")
+    cancelTarget := flag.String("cancel", "", "cancel the running check of a neId or session ID and exit")
+    cancelReason := flag.String("reason", "", "reason recorded with -cancel")
+    cancelBy := flag.String("by", os.Getenv("USER"), "who cancelled, recorded with -cancel")
+    flag.Parse()
+
+    // Load config (YAML files, .env, environment)
     cfg, err := config.Load()
     if err != nil {
         log.Fatalf("Failed to load config: %v", err)
     }
-    fmt.Printf("✓ Config loaded (Env: %s)\n\n", cfg.App.Environment)
+
+    logFile, err := logging.Setup(logging.Config{
+        Level:      cfg.Logging.Level,
+        File:       cfg.Logging.File,
+        MaxSizeMB:  cfg.Logging.MaxSizeMB,
+        MaxBackups: cfg.Logging.MaxBackups,
+    })
+    if err != nil {
+        log.Fatalf("Failed to set up logging: %v", err)
+    }
+    defer logFile.Close()
 
     // Connect to database
-    fmt.Println("Connecting to database...")
-    fmt.Printf("  Host: %s:%s\n", cfg.Database.Host, cfg.Database.Port)
-    fmt.Printf("  Database: %s\n\n", cfg.Database.Database)
-    
-    // Convert config.DatabaseConfig to database.Config
     dbConfig := database.Config{
         Host:     cfg.Database.Host,
         Port:     cfg.Database.Port,
@@ -44,29 +58,216 @@ func main() {
         Password: cfg.Database.Password,
         Database: cfg.Database.Database,
     }
-    
+
     db, err := database.Connect(dbConfig)
     if err != nil {
-        log.Fatalf("Failed to connect: %v", err)
+        log.Fatalf("Failed to connect to database: %v", err)
     }
     defer db.Close()
-    fmt.Println("✓ Database connected\n")
-
-    // Test queries
-    fmt.Println("Running test queries...")
-    
-    var userCount, nodeCount, proxyCount, serverCount int
-    
-    db.QueryRow("SELECT COUNT(*) FROM hc_niam_users").Scan(&userCount)
-    db.QueryRow("SELECT COUNT(*) FROM hc_nodes").Scan(&nodeCount)
-    db.QueryRow("SELECT COUNT(*) FROM hc_mito_proxies WHERE is_active=1").Scan(&proxyCount)
-    db.QueryRow("SELECT COUNT(*) FROM hc_app_servers WHERE is_active=1").Scan(&serverCount)
-
-    fmt.Printf("  NIAM Users: %d\n", userCount)
-    fmt.Printf("  Nodes: %d\n", nodeCount)
-    fmt.Printf("  Active Proxies: %d\n", proxyCount)
-    fmt.Printf("  Active App Servers: %d\n\n", serverCount)
-
-    fmt.Println("✅ All tests passed!")
-    fmt.Println("===========================================")
+
+    if *cancelTarget != "" {
+        cancelCheck(status.NewManager(db.DB), *cancelTarget, *cancelReason, *cancelBy)
+        return
+    }
+
+    profiles, err := profile.Load(cfg.Path("command_profiles.yaml"))
+    if err != nil {
+        log.Fatalf("Failed to load command profiles: %v", err)
+    }
+
+    scorer, err := scoring.Load(cfg.Path("scoring.yaml"))
+    if err != nil {
+        log.Fatalf("Failed to load scoring rules: %v", err)
+    }
+
+    var secrets *secret.Keyring
+    if cfg.Secrets.KeyFile != "" {
+        secrets, err = secret.Load(cfg.Secrets.KeyFile)
+        if err != nil {
+            log.Fatalf("Failed to load key file: %v", err)
+        }
+    }
+
+    // Wire up managers
+    invMgr := inventory.NewManager(db.DB)
+    invMgr.SetRecheckInterval(cfg.App.RecheckInterval)
+
+    hostKeys, err := session.HostKeys(cfg.SSH.KnownHosts, cfg.SSH.InsecureIgnoreHostKey)
+    if err != nil {
+        log.Fatalf("Failed to set up host key checking: %v", err)
+    }
+    if cfg.SSH.InsecureIgnoreHostKey {
+        log.Printf("WARNING: SSH host keys are not verified (ssh.insecure_ignore_host_key)")
+    }
+
+    sessionMgr := session.NewManager(session.Config{
+        Timeout:           cfg.SSH.Timeout,
+        MaxRetries:        cfg.SSH.MaxRetries,
+        KeepaliveInterval: cfg.SSH.KeepaliveInterval,
+        ProxyPassword:     cfg.MitoProxy.Password,
+        Secrets:           secrets,
+        HostKeyCallback:   hostKeys,
+    })
+
+    userPool := userpool.NewPool(db.DB, userpool.Config{
+        MaxSessionsPerUser: cfg.UserPool.MaxSessionsPerUser,
+        MaxWaitTime:        cfg.UserPool.MaxWaitForUser,
+        CheckInterval:      cfg.UserPool.CheckInterval,
+        LeaseTTL:           cfg.UserPool.LeaseTTL,
+        ExpiryWarningDays:  cfg.UserPool.ExpiryWarningDays,
+        MaxAuthFailures:    cfg.UserPool.MaxAuthFailures,
+        LockoutDuration:    cfg.UserPool.LockoutDuration,
+    })
+    statusMgr := status.NewManager(db.DB)
+    appServers := appserver.NewManager(db.DB)
+    historyRec := history.NewRecorder(db.DB)
+    sessionTracker := tracker.NewTracker(db.DB)
+    proxyMgr := proxy.NewManager(db.DB)
+    proxyStrategy, err := proxy.NewStrategy(cfg.Infrastructure.MitoProxies.Strategy)
+    if err != nil {
+        log.Fatalf("Invalid proxy configuration: %v", err)
+    }
+    proxyMgr.SetStrategy(proxyStrategy)
+    proxyMgr.SetFailover(proxy.FailoverConfig{
+        RetryAttempts: cfg.Infrastructure.MitoProxies.RetryAttempts,
+        RetryDelay:    cfg.Infrastructure.MitoProxies.RetryDelay,
+    })
+    if cb := cfg.Infrastructure.MitoProxies.CircuitBreaker; cb.Enabled {
+        proxyMgr.SetBreaker(proxy.BreakerConfig{
+            Window:       cb.Window,
+            MinRequests:  cb.MinRequests,
+            FailureRatio: cb.FailureRatio,
+            Cooldown:     cb.Cooldown,
+        })
+    }
+
+    exec := executor.New(
+        executor.Config{
+            MaxConcurrentChecks: cfg.App.MaxConcurrentChecks,
+            MaxWait:             cfg.App.MaxWait,
+            CommandTimeout:      cfg.App.CommandTimeout,
+            HeartbeatInterval:   cfg.App.HeartbeatInterval,
+            Retry:               retryPolicy(cfg.App),
+        },
+        executor.Managers{
+            Inventory: invMgr,
+            Users:     userPool,
+            Proxies:   proxyMgr,
+            Servers:   appServers,
+            Status:    statusMgr,
+            Sessions:  sessionMgr,
+            Profiles:  profiles,
+            History:   historyRec,
+            Scoring:   scorer,
+            Tracker:   sessionTracker,
+        },
+    )
+
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    reaper := tracker.NewReaper(sessionTracker, userPool, statusMgr, historyRec, cfg.App.StaleSessionAfter)
+    go reaper.Run(ctx, cfg.App.HeartbeatInterval)
+    go userPool.RunReclaimer(ctx, cfg.App.HeartbeatInterval)
+    go userPool.RunExpiryCheck(ctx, cfg.UserPool.ExpiryCheckInterval)
+    go exec.WatchCancels(ctx, cfg.App.CancelPollInterval)
+
+    if cfg.App.MaxWait > 0 {
+        sweeper := tracker.NewSweeper(sessionTracker, userPool, statusMgr, historyRec, cfg.App.MaxWait)
+        go sweeper.Run(ctx, cfg.App.HeartbeatInterval)
+    }
+
+    if probe := cfg.Infrastructure.MitoProxies.Probe; probe.Enabled {
+        prober := proxy.NewProber(proxyMgr, proxy.ProberConfig{
+            Interval:          probe.Interval,
+            Timeout:           probe.Timeout,
+            FailureThreshold:  probe.FailureThreshold,
+            RecoveryThreshold: probe.RecoveryThreshold,
+        })
+        go prober.Run(ctx)
+    }
+
+    if cfg.API.Enabled {
+        apiServer := api.NewServer(
+            api.Config{
+                Listen:           cfg.API.Listen,
+                Token:            cfg.API.Token,
+                AllowedOrigins:   cfg.API.AllowedOrigins,
+                LivePollInterval: cfg.API.LivePollInterval,
+                LiveReplay:       cfg.API.LiveReplay,
+            },
+            api.Managers{
+                Inventory: invMgr,
+                Status:    statusMgr,
+                History:   historyRec,
+                Users:     userPool,
+                Proxies:   proxyMgr,
+                Servers:   appServers,
+            },
+        )
+        go func() {
+            if err := apiServer.Run(ctx); err != nil {
+                log.Fatalf("API server failed: %v", err)
+            }
+        }()
+    }
+
+    log.Printf("Health check system started (Env: %s, max concurrent: %d, poll interval: %s)",
+        cfg.App.Environment, cfg.App.MaxConcurrentChecks, cfg.App.PollInterval)
+
+    run(ctx, exec, cfg.App.PollInterval)
+
+    log.Printf("Shutting down, waiting for %d running checks", exec.Active())
+    exec.Wait()
+    log.Println("Health check system stopped")
+}
+
+// run schedules checks every poll interval until ctx is cancelled
+func run(ctx context.Context, exec *executor.Executor, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        started, err := exec.RunOnce(ctx)
+        if err != nil {
+            log.Printf("Scheduling failed: %v", err)
+        } else if started > 0 {
+            log.Printf("Started %d checks (%d running)", started, exec.Active())
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// cancelCheck asks the process running the check of a node or session to
+// cancel it
+func cancelCheck(st *status.Manager, target, reason, by string) {
+    if by == "" {
+        by = "unknown"
+    }
+
+    req, err := st.RequestCancel(target, reason, by)
+    if err != nil {
+        log.Fatalf("Failed to cancel %s: %v", target, err)
+    }
+    log.Printf("Requested cancel of check %s on %s", req.SessionID, req.NeID)
+}
+
+// retryPolicy builds the executor retry policy from the health_check settings
+func retryPolicy(app config.AppConfig) executor.RetryPolicy {
+    retryOn, err := executor.ParseErrorClasses(app.RetryOn)
+    if err != nil {
+        log.Fatalf("Invalid retry_on: %v", err)
+    }
+
+    return executor.RetryPolicy{
+        MaxRetries: app.MaxRetries,
+        Delay:      app.RetryDelay,
+        MaxDelay:   app.MaxRetryDelay,
+        RetryOn:    retryOn,
+    }
 }
@@ -1,17 +1,18 @@
+//go:build ignore
+
 package main
 
 import (
     "fmt"
     "log"
     
+    "health-check-system/pkg/appserver"
     "health-check-system/pkg/config"
     "health-check-system/pkg/database"
     "health-check-system/pkg/userpool"
     "health-check-system/pkg/proxy"
     "health-check-system/pkg/inventory"
     "health-check-system/pkg/status"
-    
-    "github.com/joho/godotenv"
 )
 
 func main() {
@@ -20,10 +21,7 @@ func main() {
     fmt.Println("===========================================")
     fmt.Println()
 
-    // Load .env
-    godotenv.Load()
-
-    // Load config
+    // Load config (YAML files, .env, environment)
     cfg, err := config.Load()
     if err != nil {
         log.Fatalf("Failed to load config: %v", err)
@@ -44,22 +42,37 @@ func main() {
     }
     defer db.Close()
 
-    fmt.Println("✓ Database connected\n")
+    fmt.Println("✓ Database connected")
+    fmt.Println()
 
     // Test User Pool
     fmt.Println("=== Testing User Pool ===")
-    userPool := userpool.NewPool(db.DB)
+    userPool := userpool.NewPool(db.DB, userpool.Config{
+        MaxSessionsPerUser: cfg.UserPool.MaxSessionsPerUser,
+        MaxWaitTime:        cfg.UserPool.MaxWaitForUser,
+        CheckInterval:      cfg.UserPool.CheckInterval,
+        LeaseTTL:           cfg.UserPool.LeaseTTL,
+        ExpiryWarningDays:  cfg.UserPool.ExpiryWarningDays,
+        MaxAuthFailures:    cfg.UserPool.MaxAuthFailures,
+        LockoutDuration:    cfg.UserPool.LockoutDuration,
+    })
     
     poolStatus, err := userPool.GetPoolStatus()
     if err != nil {
         log.Fatalf("Failed to get pool status: %v", err)
     }
     
-    fmt.Printf("Total Users: %v\n", poolStatus["total_users"])
-    fmt.Printf("Active Users: %v\n", poolStatus["active_users"])
-    fmt.Printf("Total Capacity: %v\n", poolStatus["total_capacity"])
-    fmt.Printf("Used Capacity: %v\n", poolStatus["used_capacity"])
-    fmt.Printf("Available Capacity: %v\n\n", poolStatus["available_capacity"])
+    fmt.Printf("Total Users: %d\n", poolStatus.TotalUsers)
+    fmt.Printf("Active Users: %d (expired %d, locked %d)\n",
+        poolStatus.ActiveUsers, poolStatus.ExpiredUsers, poolStatus.LockedUsers)
+    fmt.Printf("Total Capacity: %d\n", poolStatus.TotalCapacity)
+    fmt.Printf("Used Capacity: %d\n", poolStatus.UsedCapacity)
+    fmt.Printf("Available Capacity: %d\n", poolStatus.AvailableCapacity)
+    for _, srv := range poolStatus.Servers {
+        fmt.Printf("  NIAM %s: %d users, %d/%d sessions used\n",
+            srv.NiamIP, srv.Users, srv.UsedCapacity, srv.TotalCapacity)
+    }
+    fmt.Println()
 
     // Test Proxy Manager
     fmt.Println("=== Testing Proxy Manager ===")
@@ -74,6 +87,19 @@ func main() {
     allProxies, _ := proxyMgr.GetAllProxies()
     fmt.Printf("Total Proxies Available: %d\n\n", len(allProxies))
 
+    // Test App Server Manager
+    fmt.Println("=== Testing App Server Manager ===")
+    serverMgr := appserver.NewManager(db.DB)
+
+    server, err := serverMgr.GetServer()
+    if err != nil {
+        log.Fatalf("Failed to get app server: %v", err)
+    }
+    fmt.Printf("Primary App Server: %s (%s) load %d/%d\n", server.Name, server.IP, server.CurrentLoad, server.MaxLoad)
+
+    allServers, _ := serverMgr.GetAllServers()
+    fmt.Printf("Total App Servers Available: %d\n\n", len(allServers))
+
     // Test Inventory Manager
     fmt.Println("=== Testing Inventory Manager ===")
     invMgr := inventory.NewManager(db.DB)
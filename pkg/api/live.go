@@ -0,0 +1,474 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+
+    "health-check-system/pkg/status"
+)
+
+const (
+    // subscriberBuffer is the number of updates queued for a stream
+    // before it is dropped as too slow. Dropped SSE clients reconnect and
+    // resume from Last-Event-ID.
+    subscriberBuffer = 256
+
+    // tailBatch is the number of rows read from hc_live_updates at a time
+    tailBatch = 500
+
+    // maxReplay caps the updates sent when a stream opens or resumes. A
+    // resuming stream that missed more gets a reset event first.
+    maxReplay = 1000
+
+    // liveSettle is how long rows are re-read after they were first seen.
+    // Concurrent inserts may commit out of ID order, so a row can appear
+    // below IDs that were already published.
+    liveSettle = 5 * time.Second
+
+    // streamPing is how often idle streams are pinged so proxies keep
+    // them open
+    streamPing = 15 * time.Second
+
+    wsWriteTimeout = 10 * time.Second
+)
+
+// subscriber is one open stream
+type subscriber struct {
+    filter  status.LiveFilter
+    updates chan *status.LiveUpdate
+}
+
+// liveSource reads hc_live_updates; it is implemented by *status.Manager
+type liveSource interface {
+    GetLastLiveUpdateID() (int64, error)
+    TailLiveUpdates(afterID int64, limit int) ([]*status.LiveUpdate, error)
+    GetLiveUpdates(f status.LiveFilter, afterID int64, limit int) ([]*status.LiveUpdate, error)
+}
+
+// hub follows hc_live_updates and fans new rows out to the open streams,
+// so the table is polled once however many clients are connected
+type hub struct {
+    source   liveSource
+    interval time.Duration
+
+    mu   sync.Mutex
+    subs map[*subscriber]struct{}
+
+    // The fields below are only used by run. Every poll re-reads all rows
+    // above floor, and seen holds the IDs among them that were already
+    // published, so each row goes out exactly once. marks remembers the
+    // newest ID of each poll; floor only advances to a mark once it is
+    // liveSettle old, which leaves late commits time to show up.
+    floor int64
+    seen  map[int64]struct{}
+    marks []mark
+}
+
+// mark is the newest ID seen by one poll
+type mark struct {
+    at time.Time
+    id int64
+}
+
+func newHub(source liveSource, interval time.Duration) *hub {
+    return &hub{
+        source:   source,
+        interval: interval,
+        subs:     make(map[*subscriber]struct{}),
+        seen:     make(map[int64]struct{}),
+    }
+}
+
+func (h *hub) subscribe(f status.LiveFilter) *subscriber {
+    sub := &subscriber{
+        filter:  f,
+        updates: make(chan *status.LiveUpdate, subscriberBuffer),
+    }
+
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.subs[sub] = struct{}{}
+    return sub
+}
+
+func (h *hub) unsubscribe(sub *subscriber) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.drop(sub)
+}
+
+// drop closes a subscriber's channel; the caller holds mu
+func (h *hub) drop(sub *subscriber) {
+    if _, ok := h.subs[sub]; ok {
+        delete(h.subs, sub)
+        close(sub.updates)
+    }
+}
+
+// run follows the table every interval until ctx is done, then closes
+// every stream
+func (h *hub) run(ctx context.Context) {
+    ticker := time.NewTicker(h.interval)
+    defer ticker.Stop()
+
+    defer func() {
+        h.mu.Lock()
+        defer h.mu.Unlock()
+        for sub := range h.subs {
+            h.drop(sub)
+        }
+    }()
+
+    // Only rows added from now on are published; streams replay older
+    // ones themselves
+    started := false
+    for {
+        if !started {
+            lastID, err := h.source.GetLastLiveUpdateID()
+            if err != nil {
+                log.Printf("Failed to read live updates: %v", err)
+            } else {
+                h.floor, started = lastID, true
+            }
+        } else {
+            h.poll(time.Now())
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// poll publishes the rows above the floor that were not published yet,
+// then moves the floor up to the newest ID seen liveSettle ago
+func (h *hub) poll(now time.Time) {
+    newest := h.floor
+    after := h.floor
+    for {
+        updates, err := h.source.TailLiveUpdates(after, tailBatch)
+        if err != nil {
+            log.Printf("Failed to read live updates: %v", err)
+            return
+        }
+        for _, u := range updates {
+            if _, ok := h.seen[u.ID]; !ok {
+                h.seen[u.ID] = struct{}{}
+                h.publish(u)
+            }
+            after = u.ID
+        }
+        if after > newest {
+            newest = after
+        }
+        if len(updates) < tailBatch {
+            break
+        }
+    }
+
+    h.marks = append(h.marks, mark{at: now, id: newest})
+    settled := 0
+    for settled < len(h.marks) && now.Sub(h.marks[settled].at) >= liveSettle {
+        h.floor = h.marks[settled].id
+        settled++
+    }
+    h.marks = h.marks[settled:]
+
+    for id := range h.seen {
+        if id <= h.floor {
+            delete(h.seen, id)
+        }
+    }
+}
+
+// publish sends u to every matching stream, dropping streams that fell
+// behind
+func (h *hub) publish(u *status.LiveUpdate) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for sub := range h.subs {
+        if !sub.filter.Match(u) {
+            continue
+        }
+        select {
+        case sub.updates <- u:
+        default:
+            log.Printf("Dropping slow live update stream")
+            h.drop(sub)
+        }
+    }
+}
+
+// stream is an open subscription and the updates to replay before it
+type stream struct {
+    sub    *subscriber
+    recent []*status.LiveUpdate
+
+    // truncated is set when a resuming client missed more than maxReplay
+    // updates, so recent does not reach back to its last event
+    truncated bool
+}
+
+// resetEvent is sent before the replay of a truncated resume. Updates between
+// the client's last event and first_id were lost.
+type resetEvent struct {
+    Event   string `json:"event"`
+    After   int64  `json:"after"`
+    FirstID int64  `json:"first_id"`
+}
+
+func (st *stream) reset(afterID int64) resetEvent {
+    return resetEvent{Event: "reset", After: afterID, FirstID: st.recent[0].ID}
+}
+
+// openStream subscribes to the updates selected by the request and reads
+// the ones to replay. Query parameters: session_id and neId filter the
+// stream, replay sets the number of recent updates sent first and after
+// (or the SSE Last-Event-ID header) resumes after an update ID.
+func (s *Server) openStream(r *http.Request) (*stream, int64, error) {
+    q := r.URL.Query()
+    f := status.LiveFilter{
+        SessionID: q.Get("session_id"),
+        NeID:      q.Get("neId"),
+    }
+
+    replay := s.cfg.LiveReplay
+    if v := q.Get("replay"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            return nil, 0, fmt.Errorf("invalid replay %q", v)
+        }
+        replay = n
+    }
+
+    var afterID int64
+    after := r.Header.Get("Last-Event-ID")
+    if after == "" {
+        after = q.Get("after")
+    }
+    if after != "" {
+        id, err := strconv.ParseInt(after, 10, 64)
+        if err != nil || id < 0 {
+            return nil, 0, fmt.Errorf("invalid event ID %q", after)
+        }
+        // A resuming client wants everything it missed
+        afterID, replay = id, maxReplay
+    }
+    if replay > maxReplay {
+        replay = maxReplay
+    }
+
+    // Subscribe before reading the replay so that no update falls in
+    // between; the overlap is skipped by ID
+    st := &stream{sub: s.live.subscribe(f)}
+
+    if replay > 0 {
+        // One extra row tells whether a resume missed more than the replay
+        recent, err := s.live.source.GetLiveUpdates(f, afterID, replay+1)
+        if err != nil {
+            s.live.unsubscribe(st.sub)
+            return nil, 0, fmt.Errorf("failed to read live updates: %w", err)
+        }
+        if len(recent) > replay {
+            recent = recent[1:]
+            st.truncated = afterID > 0
+        }
+        st.recent = recent
+    }
+
+    return st, afterID, nil
+}
+
+// replayed returns the IDs of the replayed updates, which the
+// subscription may deliver again
+func (st *stream) replayed() map[int64]struct{} {
+    ids := make(map[int64]struct{}, len(st.recent))
+    for _, u := range st.recent {
+        ids[u.ID] = struct{}{}
+    }
+    return ids
+}
+
+// handleLiveSSE streams live updates as Server-Sent Events
+func (s *Server) handleLiveSSE(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        writeError(w, http.StatusInternalServerError, "streaming not supported")
+        return
+    }
+
+    st, afterID, err := s.openStream(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    defer s.live.unsubscribe(st.sub)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    if st.truncated {
+        data, _ := json.Marshal(st.reset(afterID))
+        if _, err := fmt.Fprintf(w, "event: reset\ndata: %s\n\n", data); err != nil {
+            return
+        }
+    }
+    for _, u := range st.recent {
+        if err := writeEvent(w, u); err != nil {
+            return
+        }
+    }
+    flusher.Flush()
+    replayed := st.replayed()
+
+    ping := time.NewTicker(streamPing)
+    defer ping.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-ping.C:
+            if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+                return
+            }
+        case u, ok := <-st.sub.updates:
+            if !ok {
+                return
+            }
+            if _, ok := replayed[u.ID]; ok {
+                continue
+            }
+            if err := writeEvent(w, u); err != nil {
+                return
+            }
+        }
+        flusher.Flush()
+    }
+}
+
+// writeEvent writes one update as a progress event
+func writeEvent(w http.ResponseWriter, u *status.LiveUpdate) error {
+    data, err := json.Marshal(u)
+    if err != nil {
+        return err
+    }
+    _, err = fmt.Fprintf(w, "id: %d\nevent: progress\ndata: %s\n\n", u.ID, data)
+    return err
+}
+
+// handleLiveWS streams live updates over a WebSocket, one JSON message
+// per update
+func (s *Server) handleLiveWS(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+        return
+    }
+
+    st, afterID, err := s.openStream(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    defer s.live.unsubscribe(st.sub)
+
+    upgrader := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+    conn, err := upgrader.Upgrade(w, r, nil)
+    if err != nil {
+        // Upgrade has already replied
+        return
+    }
+    defer conn.Close()
+
+    // The client sends nothing but control frames; reading is needed to
+    // process them and to notice when it goes away
+    closed := make(chan struct{})
+    go func() {
+        defer close(closed)
+        conn.SetReadLimit(512)
+        for {
+            if _, _, err := conn.NextReader(); err != nil {
+                return
+            }
+        }
+    }()
+
+    send := func(v interface{}) error {
+        conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+        return conn.WriteJSON(v)
+    }
+
+    if st.truncated {
+        if err := send(st.reset(afterID)); err != nil {
+            return
+        }
+    }
+    for _, u := range st.recent {
+        if err := send(u); err != nil {
+            return
+        }
+    }
+    replayed := st.replayed()
+
+    ping := time.NewTicker(streamPing)
+    defer ping.Stop()
+
+    for {
+        select {
+        case <-closed:
+            return
+        case <-r.Context().Done():
+            conn.WriteControl(websocket.CloseMessage,
+                websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+                time.Now().Add(wsWriteTimeout))
+            return
+        case <-ping.C:
+            if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+                return
+            }
+        case u, ok := <-st.sub.updates:
+            if !ok {
+                conn.WriteControl(websocket.CloseMessage,
+                    websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream closed"),
+                    time.Now().Add(wsWriteTimeout))
+                return
+            }
+            if _, ok := replayed[u.ID]; ok {
+                continue
+            }
+            if err := send(u); err != nil {
+                return
+            }
+        }
+    }
+}
+
+// checkOrigin allows WebSocket connections from the API's own origin,
+// non-browser clients and the configured origins
+func (s *Server) checkOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" || s.allowedOrigin(origin) {
+        return true
+    }
+    u, err := url.Parse(origin)
+    return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+    "net/http/httptest"
+    "sort"
+    "sync"
+    "testing"
+    "time"
+
+    "health-check-system/pkg/status"
+)
+
+// fakeSource is an hc_live_updates table whose rows become visible when
+// committed, in any ID order
+type fakeSource struct {
+    mu   sync.Mutex
+    rows map[int64]*status.LiveUpdate
+}
+
+func newFakeSource() *fakeSource {
+    return &fakeSource{rows: make(map[int64]*status.LiveUpdate)}
+}
+
+func (f *fakeSource) commit(ids ...int64) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    for _, id := range ids {
+        f.rows[id] = &status.LiveUpdate{ID: id, SessionID: "HC_1", NeID: "NE1", Status: "running"}
+    }
+}
+
+// above returns the committed rows with an ID above afterID, oldest first
+func (f *fakeSource) above(afterID int64, match func(*status.LiveUpdate) bool) []*status.LiveUpdate {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    var rows []*status.LiveUpdate
+    for id, u := range f.rows {
+        if id > afterID && match(u) {
+            rows = append(rows, u)
+        }
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+    return rows
+}
+
+func (f *fakeSource) GetLastLiveUpdateID() (int64, error) {
+    rows := f.above(0, func(*status.LiveUpdate) bool { return true })
+    if len(rows) == 0 {
+        return 0, nil
+    }
+    return rows[len(rows)-1].ID, nil
+}
+
+func (f *fakeSource) TailLiveUpdates(afterID int64, limit int) ([]*status.LiveUpdate, error) {
+    rows := f.above(afterID, func(*status.LiveUpdate) bool { return true })
+    if len(rows) > limit {
+        rows = rows[:limit]
+    }
+    return rows, nil
+}
+
+func (f *fakeSource) GetLiveUpdates(filter status.LiveFilter, afterID int64, limit int) ([]*status.LiveUpdate, error) {
+    rows := f.above(afterID, filter.Match)
+    if len(rows) > limit {
+        rows = rows[len(rows)-limit:]
+    }
+    return rows, nil
+}
+
+// collect subscribes to h and returns a func that unsubscribes and
+// reports the IDs received, in order. The subscriber is buffered for a
+// whole backlog so that it is never dropped as slow.
+func collect(h *hub) func() []int64 {
+    sub := &subscriber{updates: make(chan *status.LiveUpdate, 4*tailBatch)}
+    h.mu.Lock()
+    h.subs[sub] = struct{}{}
+    h.mu.Unlock()
+
+    done := make(chan []int64)
+    go func() {
+        var ids []int64
+        for u := range sub.updates {
+            ids = append(ids, u.ID)
+        }
+        done <- ids
+    }()
+    return func() []int64 {
+        h.unsubscribe(sub)
+        return <-done
+    }
+}
+
+func TestHubOutOfOrderCommits(t *testing.T) {
+    src := newFakeSource()
+    src.commit(1, 2)
+
+    h := newHub(src, time.Second)
+    h.floor, _ = src.GetLastLiveUpdateID()
+    received := collect(h)
+
+    start := time.Now()
+    at := func(d time.Duration) time.Time { return start.Add(d) }
+
+    // 4 and 7 commit after higher IDs were already published
+    src.commit(3, 5)
+    h.poll(at(0))
+    src.commit(4, 6, 8)
+    h.poll(at(time.Second))
+    src.commit(7)
+    h.poll(at(2 * time.Second))
+    h.poll(at(3 * time.Second))
+
+    // Once settled, the floor moves up and seen is pruned
+    h.poll(at(10 * time.Second))
+    if h.floor != 8 {
+        t.Errorf("floor = %d, want 8", h.floor)
+    }
+    if len(h.seen) != 0 {
+        t.Errorf("seen = %v, want it pruned below the floor", h.seen)
+    }
+
+    // Rows above the new floor keep flowing
+    src.commit(10)
+    h.poll(at(11 * time.Second))
+    src.commit(9)
+    h.poll(at(12 * time.Second))
+
+    got := received()
+    want := []int64{3, 5, 4, 6, 8, 7, 10, 9}
+    if len(got) != len(want) {
+        t.Fatalf("received %v, want %v", got, want)
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Fatalf("received %v, want %v", got, want)
+        }
+    }
+}
+
+func TestHubPagesThroughBacklog(t *testing.T) {
+    src := newFakeSource()
+    h := newHub(src, time.Second)
+    received := collect(h)
+
+    var ids []int64
+    for id := int64(1); id <= tailBatch+tailBatch/2; id++ {
+        ids = append(ids, id)
+    }
+    src.commit(ids...)
+    h.poll(time.Now())
+    h.poll(time.Now())
+
+    got := received()
+    if len(got) != len(ids) {
+        t.Fatalf("received %d updates, want %d", len(got), len(ids))
+    }
+    for i, id := range got {
+        if id != ids[i] {
+            t.Fatalf("update %d has ID %d, want %d", i, id, ids[i])
+        }
+    }
+}
+
+func TestOpenStreamResume(t *testing.T) {
+    src := newFakeSource()
+    var ids []int64
+    for id := int64(1); id <= maxReplay+10; id++ {
+        ids = append(ids, id)
+    }
+    src.commit(ids...)
+
+    s := &Server{cfg: Config{LiveReplay: 5}, live: newHub(src, time.Second)}
+
+    tests := []struct {
+        name          string
+        url           string
+        header        string
+        wantAfter     int64
+        wantFirst     int64
+        wantLen       int
+        wantTruncated bool
+    }{
+        {"fresh stream", "/api/v1/live", "", 0, maxReplay + 6, 5, false},
+        {"resume within replay", "/api/v1/live?after=20", "", 20, 21, maxReplay - 10, false},
+        {"resume from header", "/api/v1/live?after=20", "100", 100, 101, maxReplay - 90, false},
+        {"resume past replay", "/api/v1/live?after=2", "", 2, 11, maxReplay, true},
+        {"resume at the end", "/api/v1/live?after=1010", "", maxReplay + 10, 0, 0, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r := httptest.NewRequest("GET", tt.url, nil)
+            if tt.header != "" {
+                r.Header.Set("Last-Event-ID", tt.header)
+            }
+
+            st, afterID, err := s.openStream(r)
+            if err != nil {
+                t.Fatalf("openStream() error = %v", err)
+            }
+            defer s.live.unsubscribe(st.sub)
+
+            if afterID != tt.wantAfter {
+                t.Errorf("afterID = %d, want %d", afterID, tt.wantAfter)
+            }
+            if st.truncated != tt.wantTruncated {
+                t.Errorf("truncated = %v, want %v", st.truncated, tt.wantTruncated)
+            }
+            if len(st.recent) != tt.wantLen {
+                t.Fatalf("replayed %d updates, want %d", len(st.recent), tt.wantLen)
+            }
+            if tt.wantLen > 0 && st.recent[0].ID != tt.wantFirst {
+                t.Errorf("first replayed ID = %d, want %d", st.recent[0].ID, tt.wantFirst)
+            }
+            if tt.wantTruncated {
+                reset := st.reset(afterID)
+                if reset.Event != "reset" || reset.After != tt.wantAfter || reset.FirstID != tt.wantFirst {
+                    t.Errorf("reset = %+v", reset)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "health-check-system/pkg/appserver"
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/proxy"
+)
+
+const (
+    defaultPageSize = 100
+    maxPageSize     = 1000
+)
+
+// page is a paginated list response
+type page struct {
+    Items  interface{} `json:"items"`
+    Total  int         `json:"total"`
+    Limit  int         `json:"limit"`
+    Offset int         `json:"offset"`
+}
+
+// proxyState is a proxy with its circuit breaker state
+type proxyState struct {
+    *proxy.Proxy
+    BreakerState proxy.BreakerState `json:"breaker_state"`
+}
+
+// routes registers the REST endpoints. The standard mux of Go 1.21 has
+// no path parameters, so handlers below a prefix split the path
+// themselves.
+func (s *Server) routes() {
+    s.mux.HandleFunc("/api/v1/nodes", get(s.handleNodes))
+    s.mux.HandleFunc("/api/v1/nodes/", get(s.handleNode))
+    s.mux.HandleFunc("/api/v1/status", get(s.handleStatusSummary))
+    s.mux.HandleFunc("/api/v1/history", get(s.handleHistory))
+    s.mux.HandleFunc("/api/v1/history/", get(s.handleSession))
+    s.mux.HandleFunc("/api/v1/pool", get(s.handlePool))
+    s.mux.HandleFunc("/api/v1/proxies", get(s.handleProxies))
+    s.mux.HandleFunc("/api/v1/app-servers", get(s.handleAppServers))
+}
+
+// get rejects methods other than GET and HEAD
+func get(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            w.Header().Set("Allow", "GET, HEAD")
+            writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+            return
+        }
+        h(w, r)
+    }
+}
+
+// handleNodes lists nodes. Filters: circle, site, vendor, node_type, status.
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+    limit, offset, err := pagination(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    q := r.URL.Query()
+    nodes, total, err := s.mgr.Inventory.ListNodes(inventory.NodeFilter{
+        Circle:   q.Get("circle"),
+        Site:     q.Get("site"),
+        Vendor:   q.Get("vendor"),
+        NodeType: q.Get("node_type"),
+        Status:   q.Get("status"),
+    }, limit, offset)
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, page{Items: nodes, Total: total, Limit: limit, Offset: offset})
+}
+
+// handleNode serves /api/v1/nodes/{neId}, /api/v1/nodes/{neId}/status
+// and /api/v1/nodes/{neId}/history
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+    neID := strings.TrimPrefix(r.URL.Path, "/api/v1/nodes/")
+    sub := ""
+    if i := strings.LastIndex(neID, "/"); i >= 0 {
+        neID, sub = neID[:i], neID[i+1:]
+    }
+    if neID == "" {
+        writeError(w, http.StatusNotFound, "not found")
+        return
+    }
+
+    switch sub {
+    case "":
+        node, err := s.mgr.Inventory.GetNodeByID(neID)
+        if err != nil {
+            s.fail(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, node)
+    case "status":
+        details, err := s.mgr.Status.GetNodeDetails(neID)
+        if err != nil {
+            s.fail(w, err)
+            return
+        }
+        writeJSON(w, http.StatusOK, details)
+    case "history":
+        s.serveHistory(w, r, neID)
+    default:
+        writeError(w, http.StatusNotFound, "not found")
+    }
+}
+
+// handleStatusSummary returns the number of nodes in each status
+func (s *Server) handleStatusSummary(w http.ResponseWriter, r *http.Request) {
+    counts, err := s.mgr.Status.CountByStatus()
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, counts)
+}
+
+// handleHistory lists check sessions. Filters: neId, circle,
+// final_status, result, since, until.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+    s.serveHistory(w, r, r.URL.Query().Get("neId"))
+}
+
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request, neID string) {
+    limit, offset, err := pagination(r)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    q := r.URL.Query()
+    f := history.Filter{
+        NeID:        neID,
+        Circle:      q.Get("circle"),
+        FinalStatus: q.Get("final_status"),
+        Result:      q.Get("result"),
+    }
+    if f.Since, err = parseTime(q.Get("since")); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+        return
+    }
+    if f.Until, err = parseTime(q.Get("until")); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid until: "+err.Error())
+        return
+    }
+
+    records, total, err := s.mgr.History.Query(f, limit, offset)
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+
+    writeJSON(w, http.StatusOK, page{Items: records, Total: total, Limit: limit, Offset: offset})
+}
+
+// handleSession serves /api/v1/history/{session_id} with metrics
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+    sessionID := strings.TrimPrefix(r.URL.Path, "/api/v1/history/")
+    if sessionID == "" || strings.Contains(sessionID, "/") {
+        writeError(w, http.StatusNotFound, "not found")
+        return
+    }
+
+    rec, err := s.mgr.History.Get(sessionID)
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, rec)
+}
+
+// handlePool returns the NIAM user pool status
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+    pool, err := s.mgr.Users.GetPoolStatus()
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+    writeJSON(w, http.StatusOK, pool)
+}
+
+// handleProxies returns every Mito proxy with its circuit breaker state
+func (s *Server) handleProxies(w http.ResponseWriter, r *http.Request) {
+    proxies, err := s.mgr.Proxies.ListProxies()
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+
+    states := make([]proxyState, 0, len(proxies))
+    for _, px := range proxies {
+        states = append(states, proxyState{Proxy: px, BreakerState: s.mgr.Proxies.BreakerState(px.Name)})
+    }
+    writeJSON(w, http.StatusOK, states)
+}
+
+// handleAppServers returns the active app servers
+func (s *Server) handleAppServers(w http.ResponseWriter, r *http.Request) {
+    servers, err := s.mgr.Servers.GetAllServers()
+    if err != nil {
+        s.fail(w, err)
+        return
+    }
+    if servers == nil {
+        servers = []*appserver.Server{}
+    }
+    writeJSON(w, http.StatusOK, servers)
+}
+
+// fail writes 404 for missing rows and 500 for other errors, which are
+// logged rather than returned
+func (s *Server) fail(w http.ResponseWriter, err error) {
+    if errors.Is(err, sql.ErrNoRows) {
+        writeError(w, http.StatusNotFound, "not found")
+        return
+    }
+    log.Printf("API request failed: %v", err)
+    writeError(w, http.StatusInternalServerError, "internal error")
+}
+
+// pagination reads the limit and offset query parameters
+func pagination(r *http.Request) (int, int, error) {
+    q := r.URL.Query()
+    limit, offset := defaultPageSize, 0
+
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            return 0, 0, fmt.Errorf("invalid limit %q", v)
+        }
+        limit = n
+    }
+    if limit > maxPageSize {
+        limit = maxPageSize
+    }
+
+    if v := q.Get("offset"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 0 {
+            return 0, 0, fmt.Errorf("invalid offset %q", v)
+        }
+        offset = n
+    }
+
+    return limit, offset, nil
+}
+
+// parseTime accepts RFC 3339 times and plain dates. Empty means unset.
+func parseTime(v string) (time.Time, error) {
+    if v == "" {
+        return time.Time{}, nil
+    }
+    if t, err := time.Parse(time.RFC3339, v); err == nil {
+        return t, nil
+    }
+    return time.ParseInLocation("2006-01-02", v, time.Local)
+}
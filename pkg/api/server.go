@@ -0,0 +1,206 @@
+package api
+
+import (
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "time"
+
+    "health-check-system/pkg/appserver"
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/status"
+    "health-check-system/pkg/userpool"
+)
+
+// Config holds API server settings
+type Config struct {
+    Listen string
+
+    // Token is the bearer token every request must carry. It may only be
+    // empty when Listen is a loopback address.
+    Token string
+
+    // AllowedOrigins are the browser origins allowed to read the API,
+    // "*" allows any. Same-origin requests are always allowed.
+    AllowedOrigins []string
+
+    // LivePollInterval is how often hc_live_updates is read for streams
+    LivePollInterval time.Duration
+
+    // LiveReplay is the number of recent updates sent when a stream opens
+    LiveReplay int
+}
+
+// Managers are the components the API reads from
+type Managers struct {
+    Inventory *inventory.Manager
+    Status    *status.Manager
+    History   *history.Recorder
+    Users     *userpool.Pool
+    Proxies   *proxy.Manager
+    Servers   *appserver.Manager
+}
+
+// Server serves the HTTP API: JSON endpoints for nodes, statuses,
+// history, the user pool and the proxies and app servers, and live
+// progress streams
+type Server struct {
+    cfg  Config
+    mgr  Managers
+    live *hub
+    mux  *http.ServeMux
+}
+
+// NewServer creates an API server
+func NewServer(cfg Config, managers Managers) *Server {
+    if cfg.Listen == "" {
+        cfg.Listen = "127.0.0.1:8080"
+    }
+    if cfg.LivePollInterval <= 0 {
+        cfg.LivePollInterval = time.Second
+    }
+    if cfg.LiveReplay < 0 {
+        cfg.LiveReplay = 0
+    }
+
+    s := &Server{
+        cfg:  cfg,
+        mgr:  managers,
+        live: newHub(managers.Status, cfg.LivePollInterval),
+        mux:  http.NewServeMux(),
+    }
+
+    s.mux.HandleFunc("/api/v1/live", s.handleLiveSSE)
+    s.mux.HandleFunc("/api/v1/live/ws", s.handleLiveWS)
+    s.routes()
+
+    return s
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+    return s.cors(s.auth(s.mux))
+}
+
+// Run serves the API until ctx is done, then shuts down. Open streams
+// are closed on shutdown.
+func (s *Server) Run(ctx context.Context) error {
+    if s.cfg.Token == "" && !loopback(s.cfg.Listen) {
+        return fmt.Errorf("an API token is required to listen on %s", s.cfg.Listen)
+    }
+
+    go s.live.run(ctx)
+
+    srv := &http.Server{
+        Addr:              s.cfg.Listen,
+        Handler:           s.Handler(),
+        ReadHeaderTimeout: 10 * time.Second,
+        BaseContext:       func(net.Listener) context.Context { return ctx },
+    }
+
+    errc := make(chan error, 1)
+    go func() {
+        errc <- srv.ListenAndServe()
+    }()
+    log.Printf("API listening on %s", s.cfg.Listen)
+
+    select {
+    case err := <-errc:
+        return err
+    case <-ctx.Done():
+    }
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := srv.Shutdown(shutdownCtx); err != nil {
+        return err
+    }
+    if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
+
+// auth rejects requests without the configured bearer token. Browsers
+// cannot set headers on EventSource and WebSocket connections, so the
+// live streams also accept the token as the access_token parameter.
+func (s *Server) auth(next http.Handler) http.Handler {
+    if s.cfg.Token == "" {
+        return next
+    }
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if !ok && strings.HasPrefix(r.URL.Path, "/api/v1/live") {
+            token, ok = r.URL.Query().Get("access_token"), true
+        }
+        if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="health-check-system"`)
+            writeError(w, http.StatusUnauthorized, "unauthorized")
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// loopback reports whether a listen address only accepts local connections
+func loopback(addr string) bool {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// allowedOrigin reports whether a browser on origin may use the API
+func (s *Server) allowedOrigin(origin string) bool {
+    for _, o := range s.cfg.AllowedOrigins {
+        if o == "*" || o == origin {
+            return true
+        }
+    }
+    return false
+}
+
+// cors adds CORS headers for allowed origins
+func (s *Server) cors(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if origin := r.Header.Get("Origin"); origin != "" && s.allowedOrigin(origin) {
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            w.Header().Add("Vary", "Origin")
+            if r.Method == http.MethodOptions {
+                w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+                w.Header().Set("Access-Control-Allow-Headers", "Authorization, Last-Event-ID")
+                w.WriteHeader(http.StatusNoContent)
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        log.Printf("Failed to write API response: %v", err)
+    }
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, code int, msg string) {
+    writeJSON(w, code, map[string]string{"error": msg})
+}
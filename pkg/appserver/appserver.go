@@ -0,0 +1,196 @@
+package appserver
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+    "sync"
+)
+
+// Server represents an app server
+type Server struct {
+    Name        string `json:"name"`
+    IP          string `json:"ip"`
+    User        string `json:"user"`
+    Priority    int    `json:"priority"`
+    IsPrimary   bool   `json:"is_primary"`
+    CurrentLoad int    `json:"current_load"`
+    MaxLoad     int    `json:"max_load"`
+}
+
+// Manager manages the app server pool
+type Manager struct {
+    db *sql.DB
+    mu sync.Mutex
+}
+
+// NewManager creates a new app server manager
+func NewManager(db *sql.DB) *Manager {
+    return &Manager{
+        db: db,
+    }
+}
+
+// GetServer gets the best available server (failover support) without
+// reserving capacity on it
+func (m *Manager) GetServer() (*Server, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    query := `
+        SELECT server_name, server_ip, server_user, priority, is_primary, current_load, max_load
+        FROM hc_app_servers
+        WHERE is_active = TRUE
+          AND current_load < max_load
+        ORDER BY priority ASC
+        LIMIT 1
+    `
+
+    server := &Server{}
+    err := m.db.QueryRow(query).Scan(
+        &server.Name,
+        &server.IP,
+        &server.User,
+        &server.Priority,
+        &server.IsPrimary,
+        &server.CurrentLoad,
+        &server.MaxLoad,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("no available app server: %w", err)
+    }
+
+    return server, nil
+}
+
+// Acquire reserves one unit of load on the highest priority server with
+// spare capacity, skipping the named servers. Release must be called
+// when the work is done.
+func (m *Manager) Acquire(exclude ...string) (*Server, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    tx, err := m.db.Begin()
+    if err != nil {
+        return nil, err
+    }
+    defer tx.Rollback()
+
+    query := `
+        SELECT server_name, server_ip, server_user, priority, is_primary, current_load, max_load
+        FROM hc_app_servers
+        WHERE is_active = TRUE
+          AND current_load < max_load
+    `
+    args := make([]interface{}, 0, len(exclude))
+    if len(exclude) > 0 {
+        query += " AND server_name NOT IN (?" + strings.Repeat(", ?", len(exclude)-1) + ")"
+        for _, name := range exclude {
+            args = append(args, name)
+        }
+    }
+    query += `
+        ORDER BY priority ASC
+        LIMIT 1
+        FOR UPDATE
+    `
+
+    server := &Server{}
+    err = tx.QueryRow(query, args...).Scan(
+        &server.Name,
+        &server.IP,
+        &server.User,
+        &server.Priority,
+        &server.IsPrimary,
+        &server.CurrentLoad,
+        &server.MaxLoad,
+    )
+    if err != nil {
+        return nil, fmt.Errorf("no available app server: %w", err)
+    }
+
+    _, err = tx.Exec(`
+        UPDATE hc_app_servers
+        SET current_load = current_load + 1
+        WHERE server_name = ?
+    `, server.Name)
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return nil, err
+    }
+
+    server.CurrentLoad++
+    return server, nil
+}
+
+// Release returns one unit of load to a server
+func (m *Manager) Release(serverName string) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_app_servers
+        SET current_load = GREATEST(current_load - 1, 0)
+        WHERE server_name = ?
+    `, serverName)
+    return err
+}
+
+// RecordSuccess records a successful request through a server
+func (m *Manager) RecordSuccess(serverName string) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_app_servers
+        SET total_requests = total_requests + 1,
+            last_success = NOW()
+        WHERE server_name = ?
+    `, serverName)
+    return err
+}
+
+// RecordFailure records a failed request through a server
+func (m *Manager) RecordFailure(serverName string) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_app_servers
+        SET total_requests = total_requests + 1,
+            failed_requests = failed_requests + 1,
+            last_failure = NOW()
+        WHERE server_name = ?
+    `, serverName)
+    return err
+}
+
+// GetAllServers returns all active servers in priority order
+func (m *Manager) GetAllServers() ([]*Server, error) {
+    query := `
+        SELECT server_name, server_ip, server_user, priority, is_primary, current_load, max_load
+        FROM hc_app_servers
+        WHERE is_active = TRUE
+        ORDER BY priority ASC
+    `
+
+    rows, err := m.db.Query(query)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var servers []*Server
+    for rows.Next() {
+        server := &Server{}
+        err := rows.Scan(
+            &server.Name,
+            &server.IP,
+            &server.User,
+            &server.Priority,
+            &server.IsPrimary,
+            &server.CurrentLoad,
+            &server.MaxLoad,
+        )
+        if err != nil {
+            return nil, err
+        }
+        servers = append(servers, server)
+    }
+
+    return servers, nil
+}
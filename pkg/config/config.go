@@ -1,15 +1,32 @@
 package config
 
 import (
+    "errors"
     "fmt"
     "os"
+    "path/filepath"
     "strconv"
     "time"
+
+    "github.com/joho/godotenv"
+    "gopkg.in/yaml.v3"
 )
 
+// Config is loaded in layers: defaults, then the YAML files in the
+// configuration directory, then the .env file, then environment variables
 type Config struct {
-    Database DatabaseConfig
-    App      AppConfig
+    Dir            string
+    Database       DatabaseConfig
+    App            AppConfig
+    UserPool       UserPoolConfig
+    Logging        LoggingConfig
+    Infrastructure InfrastructureConfig
+    NIAM           NIAMConfig
+    SSH            SSHConfig
+    MitoProxy      MitoProxyConfig
+    AppServer      AppServerConfig
+    Secrets        SecretsConfig
+    API            APIConfig
 }
 
 type DatabaseConfig struct {
@@ -20,48 +37,349 @@ type DatabaseConfig struct {
     Database string
 }
 
+// AppConfig is the health_check section of health_check.yaml
 type AppConfig struct {
-    Environment         string
-    LogLevel            string
-    MaxConcurrentChecks int
-    PollInterval        time.Duration
-    MaxWait             time.Duration
+    Environment         string        `yaml:"-"`
+    MaxConcurrentChecks int           `yaml:"max_concurrent_checks"`
+    PollInterval        time.Duration `yaml:"poll_interval"`
+    MaxWait             time.Duration `yaml:"max_wait_time"`
+    CommandTimeout      time.Duration `yaml:"command_timeout"`
+    MaxRetries          int           `yaml:"max_retries"`
+    RetryDelay          time.Duration `yaml:"retry_delay"`
+    MaxRetryDelay       time.Duration `yaml:"max_retry_delay"`
+    RetryOn             []string      `yaml:"retry_on"`
+    RecheckInterval     time.Duration `yaml:"recheck_interval"`
+    HeartbeatInterval   time.Duration `yaml:"heartbeat_interval"`
+    StaleSessionAfter   time.Duration `yaml:"stale_session_after"`
+    CancelPollInterval  time.Duration `yaml:"cancel_poll_interval"`
+}
+
+// UserPoolConfig is the user_pool section of health_check.yaml
+type UserPoolConfig struct {
+    MaxSessionsPerUser  int           `yaml:"max_sessions_per_user"`
+    MaxWaitForUser      time.Duration `yaml:"max_wait_for_user"`
+    CheckInterval       time.Duration `yaml:"check_interval"`
+    LeaseTTL            time.Duration `yaml:"lease_ttl"`
+    ExpiryWarningDays   int           `yaml:"expiry_warning_days"`
+    ExpiryCheckInterval time.Duration `yaml:"expiry_check_interval"`
+    MaxAuthFailures     int           `yaml:"max_auth_failures"`
+    LockoutDuration     time.Duration `yaml:"lockout_duration"`
+}
+
+// LoggingConfig is the logging section of health_check.yaml
+type LoggingConfig struct {
+    Level      string `yaml:"level"`
+    File       string `yaml:"file"`
+    MaxSizeMB  int    `yaml:"max_size_mb"`
+    MaxBackups int    `yaml:"max_backups"`
+}
+
+// InfrastructureConfig is the infrastructure section of infrastructure.yaml
+type InfrastructureConfig struct {
+    MitoProxies MitoProxiesConfig `yaml:"mito_proxies"`
+    AppServers  FailoverConfig    `yaml:"app_servers"`
+}
+
+// MitoProxiesConfig is the mito_proxies section of infrastructure.yaml
+type MitoProxiesConfig struct {
+    FailoverConfig `yaml:",inline"`
+    Probe          ProbeConfig          `yaml:"probe"`
+    CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// ProbeConfig controls active health probing of the proxies
+type ProbeConfig struct {
+    Enabled           bool          `yaml:"enabled"`
+    Interval          time.Duration `yaml:"interval"`
+    Timeout           time.Duration `yaml:"timeout"`
+    FailureThreshold  int           `yaml:"failure_threshold"`
+    RecoveryThreshold int           `yaml:"recovery_threshold"`
+}
+
+// CircuitBreakerConfig controls when failing proxies are skipped
+type CircuitBreakerConfig struct {
+    Enabled      bool          `yaml:"enabled"`
+    Window       int           `yaml:"window"`
+    MinRequests  int           `yaml:"min_requests"`
+    FailureRatio float64       `yaml:"failure_ratio"`
+    Cooldown     time.Duration `yaml:"cooldown"`
+}
+
+// FailoverConfig describes how a pool of servers is selected and retried
+type FailoverConfig struct {
+    Strategy          string        `yaml:"strategy"`
+    ConnectionTimeout time.Duration `yaml:"connection_timeout"`
+    RetryAttempts     int           `yaml:"retry_attempts"`
+    RetryDelay        time.Duration `yaml:"retry_delay"`
+}
+
+// NIAMConfig is the niam section of infrastructure.yaml
+type NIAMConfig struct {
+    IntermediateIP    string        `yaml:"intermediate_ip"`
+    ConnectionTimeout time.Duration `yaml:"connection_timeout"`
+}
+
+// SSHConfig is the ssh section of infrastructure.yaml
+type SSHConfig struct {
+    Timeout           time.Duration `yaml:"timeout"`
+    KeepaliveInterval time.Duration `yaml:"keepalive_interval"`
+
+    // MaxRetries applies to sessions opened without proxy failover; the
+    // health checks retry through mito_proxies.retry_attempts instead
+    MaxRetries int `yaml:"max_retries"`
+
+    // KnownHosts lists the host keys of the proxies, NIAM servers and
+    // nodes. InsecureIgnoreHostKey skips the check.
+    KnownHosts            string `yaml:"known_hosts"`
+    InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+}
+
+// SecretsConfig locates the key file used to decrypt enc:v1: passwords
+type SecretsConfig struct {
+    KeyFile string `yaml:"key_file"`
+}
+
+// APIConfig is the api section of health_check.yaml
+type APIConfig struct {
+    Enabled          bool          `yaml:"enabled"`
+    Listen           string        `yaml:"listen"`
+    Token            string        `yaml:"-"`
+    AllowedOrigins   []string      `yaml:"allowed_origins"`
+    LivePollInterval time.Duration `yaml:"live_poll_interval"`
+    LiveReplay       int           `yaml:"live_replay"`
+}
+
+type MitoProxyConfig struct {
+    User     string
+    Password string
+}
+
+type AppServerConfig struct {
+    User     string
+    Password string
 }
 
 func Load() (*Config, error) {
-    cfg := &Config{
+    cfg := defaults()
+
+    // CONFIG_DIR and ENV_FILE locate the other layers, so they are only
+    // read from the environment
+    if dir := os.Getenv("CONFIG_DIR"); dir != "" {
+        cfg.Dir = dir
+    }
+
+    if err := loadYAML(cfg.Path("health_check.yaml"), healthCheckDoc(cfg)); err != nil {
+        return nil, err
+    }
+    if err := loadYAML(cfg.Path("infrastructure.yaml"), infrastructureDoc(cfg)); err != nil {
+        return nil, err
+    }
+
+    envFile := os.Getenv("ENV_FILE")
+    if envFile == "" {
+        envFile = ".env"
+    }
+    dotenv, err := godotenv.Read(envFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return nil, fmt.Errorf("failed to read %s: %w", envFile, err)
+    }
+
+    applyEnv(cfg, env{dotenv: dotenv})
+
+    if cfg.Database.Password == "" {
+        return nil, fmt.Errorf("DB_PASSWORD is required")
+    }
+
+    return cfg, nil
+}
+
+// defaults returns the configuration used when no layer sets a value
+func defaults() *Config {
+    return &Config{
+        Dir: "config",
         Database: DatabaseConfig{
-            Host:     getEnv("DB_HOST", "localhost"),
-            Port:     getEnv("DB_PORT", "3306"),
-            User:     getEnv("DB_USER", "root"),
-            Password: getEnv("DB_PASSWORD", ""),
-            Database: getEnv("DB_NAME", "mito_inventory"),
+            Host:     "localhost",
+            Port:     "3306",
+            User:     "root",
+            Database: "mito_inventory",
         },
         App: AppConfig{
-            Environment:         getEnv("ENVIRONMENT", "development"),
-            LogLevel:            getEnv("LOG_LEVEL", "INFO"),
-            MaxConcurrentChecks: getEnvInt("MAX_CONCURRENT_CHECKS", 50),
-            PollInterval:        getEnvDuration("HC_POLL_INTERVAL", 30*time.Second),
-            MaxWait:             getEnvDuration("HC_MAX_WAIT", 80*time.Minute),
+            Environment:         "development",
+            MaxConcurrentChecks: 50,
+            PollInterval:        30 * time.Second,
+            MaxWait:             80 * time.Minute,
+            CommandTimeout:      60 * time.Second,
+            MaxRetries:          3,
+            RetryDelay:          10 * time.Second,
+            MaxRetryDelay:       5 * time.Minute,
+            // As executor.DefaultRetryOn, proxy errors are already retried
+            // over every proxy by the proxy manager
+            RetryOn:             []string{"connect", "resource"},
+            RecheckInterval:     time.Hour,
+            HeartbeatInterval:   30 * time.Second,
+            StaleSessionAfter:   2 * time.Minute,
+            CancelPollInterval:  5 * time.Second,
+        },
+        UserPool: UserPoolConfig{
+            MaxSessionsPerUser:  5,
+            MaxWaitForUser:      5 * time.Minute,
+            CheckInterval:       2 * time.Second,
+            LeaseTTL:            2 * time.Minute,
+            ExpiryWarningDays:   7,
+            ExpiryCheckInterval: time.Hour,
+            MaxAuthFailures:     3,
+            LockoutDuration:     30 * time.Minute,
+        },
+        Logging: LoggingConfig{
+            Level:      "INFO",
+            MaxSizeMB:  100,
+            MaxBackups: 5,
+        },
+        Infrastructure: InfrastructureConfig{
+            MitoProxies: MitoProxiesConfig{
+                FailoverConfig: FailoverConfig{
+                    Strategy:          "failover",
+                    ConnectionTimeout: 30 * time.Second,
+                    RetryAttempts:     3,
+                    RetryDelay:        5 * time.Second,
+                },
+                Probe: ProbeConfig{
+                    Enabled:           true,
+                    Interval:          time.Minute,
+                    Timeout:           10 * time.Second,
+                    FailureThreshold:  3,
+                    RecoveryThreshold: 2,
+                },
+                CircuitBreaker: CircuitBreakerConfig{
+                    Enabled:      true,
+                    Window:       20,
+                    MinRequests:  5,
+                    FailureRatio: 0.5,
+                    Cooldown:     time.Minute,
+                },
+            },
+            AppServers: FailoverConfig{
+                Strategy:          "failover",
+                ConnectionTimeout: 30 * time.Second,
+                RetryAttempts:     2,
+            },
+        },
+        NIAM: NIAMConfig{
+            ConnectionTimeout: 30 * time.Second,
+        },
+        SSH: SSHConfig{
+            Timeout:           30 * time.Second,
+            KeepaliveInterval: 10 * time.Second,
+            MaxRetries:        3,
+            KnownHosts:        "config/known_hosts",
+        },
+        API: APIConfig{
+            Listen:           "127.0.0.1:8080",
+            LivePollInterval: time.Second,
+            LiveReplay:       50,
         },
     }
+}
 
-    if cfg.Database.Password == "" {
-        return nil, fmt.Errorf("DB_PASSWORD is required")
+// Path returns the path of a file in the configuration directory
+func (c *Config) Path(name string) string {
+    return filepath.Join(c.Dir, name)
+}
+
+func healthCheckDoc(cfg *Config) interface{} {
+    return &struct {
+        HealthCheck *AppConfig      `yaml:"health_check"`
+        UserPool    *UserPoolConfig `yaml:"user_pool"`
+        Logging     *LoggingConfig  `yaml:"logging"`
+        Secrets     *SecretsConfig  `yaml:"secrets"`
+        API         *APIConfig      `yaml:"api"`
+    }{&cfg.App, &cfg.UserPool, &cfg.Logging, &cfg.Secrets, &cfg.API}
+}
+
+func infrastructureDoc(cfg *Config) interface{} {
+    return &struct {
+        Infrastructure *InfrastructureConfig `yaml:"infrastructure"`
+        NIAM           *NIAMConfig           `yaml:"niam"`
+        SSH            *SSHConfig            `yaml:"ssh"`
+    }{&cfg.Infrastructure, &cfg.NIAM, &cfg.SSH}
+}
+
+// loadYAML overlays a YAML file onto doc. A missing file is skipped.
+func loadYAML(path string, doc interface{}) error {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read %s: %w", path, err)
     }
 
-    return cfg, nil
+    if err := yaml.Unmarshal(data, doc); err != nil {
+        return fmt.Errorf("failed to parse %s: %w", path, err)
+    }
+
+    return nil
 }
 
-func getEnv(key, defaultValue string) string {
+// applyEnv overlays environment variables and .env values onto cfg
+func applyEnv(cfg *Config, e env) {
+    cfg.Database.Host = e.getEnv("DB_HOST", cfg.Database.Host)
+    cfg.Database.Port = e.getEnv("DB_PORT", cfg.Database.Port)
+    cfg.Database.User = e.getEnv("DB_USER", cfg.Database.User)
+    cfg.Database.Password = e.getEnv("DB_PASSWORD", cfg.Database.Password)
+    cfg.Database.Database = e.getEnv("DB_NAME", cfg.Database.Database)
+
+    cfg.App.Environment = e.getEnv("ENVIRONMENT", cfg.App.Environment)
+    cfg.App.MaxConcurrentChecks = e.getEnvInt("MAX_CONCURRENT_CHECKS", cfg.App.MaxConcurrentChecks)
+    cfg.App.PollInterval = e.getEnvDuration("HC_POLL_INTERVAL", cfg.App.PollInterval)
+    cfg.App.MaxWait = e.getEnvDuration("HC_MAX_WAIT", cfg.App.MaxWait)
+    cfg.App.CommandTimeout = e.getEnvDuration("HC_COMMAND_TIMEOUT", cfg.App.CommandTimeout)
+    cfg.App.MaxRetries = e.getEnvInt("HC_MAX_RETRIES", cfg.App.MaxRetries)
+    cfg.App.RetryDelay = e.getEnvDuration("HC_RETRY_DELAY", cfg.App.RetryDelay)
+    cfg.App.RecheckInterval = e.getEnvDuration("HC_RECHECK_INTERVAL", cfg.App.RecheckInterval)
+    cfg.App.HeartbeatInterval = e.getEnvDuration("HC_HEARTBEAT_INTERVAL", cfg.App.HeartbeatInterval)
+    cfg.App.StaleSessionAfter = e.getEnvDuration("HC_STALE_SESSION_AFTER", cfg.App.StaleSessionAfter)
+    cfg.App.CancelPollInterval = e.getEnvDuration("HC_CANCEL_POLL_INTERVAL", cfg.App.CancelPollInterval)
+
+    cfg.Logging.Level = e.getEnv("LOG_LEVEL", cfg.Logging.Level)
+    cfg.Logging.File = e.getEnv("LOG_FILE", cfg.Logging.File)
+
+    cfg.NIAM.IntermediateIP = e.getEnv("NIAM_INTERMEDIATE", cfg.NIAM.IntermediateIP)
+
+    cfg.MitoProxy.User = e.getEnv("MITO_PROXY_USER", cfg.MitoProxy.User)
+    cfg.MitoProxy.Password = e.getEnv("MITO_PROXY_PASSWORD", cfg.MitoProxy.Password)
+    cfg.AppServer.User = e.getEnv("APP_SERVER_USER", cfg.AppServer.User)
+    cfg.AppServer.Password = e.getEnv("APP_SERVER_PASSWORD", cfg.AppServer.Password)
+
+    cfg.Secrets.KeyFile = e.getEnv("SECRET_KEY_FILE", cfg.Secrets.KeyFile)
+
+    cfg.SSH.KnownHosts = e.getEnv("SSH_KNOWN_HOSTS", cfg.SSH.KnownHosts)
+
+    cfg.API.Listen = e.getEnv("API_LISTEN", cfg.API.Listen)
+    cfg.API.Token = e.getEnv("API_TOKEN", cfg.API.Token)
+}
+
+// env looks values up in the environment first, then in the .env file
+type env struct {
+    dotenv map[string]string
+}
+
+func (e env) lookup(key string) string {
     if value := os.Getenv(key); value != "" {
         return value
     }
+    return e.dotenv[key]
+}
+
+func (e env) getEnv(key, defaultValue string) string {
+    if value := e.lookup(key); value != "" {
+        return value
+    }
     return defaultValue
 }
 
-func getEnvInt(key string, defaultValue int) int {
-    if value := os.Getenv(key); value != "" {
+func (e env) getEnvInt(key string, defaultValue int) int {
+    if value := e.lookup(key); value != "" {
         if intVal, err := strconv.Atoi(value); err == nil {
             return intVal
         }
@@ -69,8 +387,8 @@ func getEnvInt(key string, defaultValue int) int {
     return defaultValue
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-    if value := os.Getenv(key); value != "" {
+func (e env) getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+    if value := e.lookup(key); value != "" {
         if duration, err := time.ParseDuration(value); err == nil {
             return duration
         }
@@ -0,0 +1,118 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// layers writes a config directory and .env file and points Load at them.
+// Variables the test sets through the layers are cleared in the process
+// environment first.
+func layers(t *testing.T, healthCheck, dotenv string) string {
+    dir := t.TempDir()
+    if healthCheck != "" {
+        if err := os.WriteFile(filepath.Join(dir, "health_check.yaml"), []byte(healthCheck), 0600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    envFile := filepath.Join(dir, "test.env")
+    if err := os.WriteFile(envFile, []byte(dotenv), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    t.Setenv("CONFIG_DIR", dir)
+    t.Setenv("ENV_FILE", envFile)
+    for _, key := range []string{"DB_HOST", "DB_PASSWORD", "MAX_CONCURRENT_CHECKS", "HC_POLL_INTERVAL",
+        "HC_HEARTBEAT_INTERVAL", "HC_COMMAND_TIMEOUT", "LOG_LEVEL", "API_TOKEN"} {
+        t.Setenv(key, "")
+    }
+    return dir
+}
+
+func TestLoadPrecedence(t *testing.T) {
+    dir := layers(t, `
+health_check:
+  max_concurrent_checks: 10
+  poll_interval: 1m
+  heartbeat_interval: 15s
+logging:
+  level: "warn"
+`, `
+DB_PASSWORD=from-dotenv
+MAX_CONCURRENT_CHECKS=20
+HC_POLL_INTERVAL=2m
+LOG_LEVEL=debug
+`)
+    t.Setenv("MAX_CONCURRENT_CHECKS", "30")
+    t.Setenv("API_TOKEN", "from-env")
+
+    cfg, err := Load()
+    if err != nil {
+        t.Fatalf("Load() error = %v", err)
+    }
+
+    if cfg.Dir != dir {
+        t.Errorf("Dir = %q, want %q", cfg.Dir, dir)
+    }
+    tests := []struct {
+        name      string
+        got, want interface{}
+    }{
+        {"environment over .env and YAML", cfg.App.MaxConcurrentChecks, 30},
+        {"environment only", cfg.API.Token, "from-env"},
+        {".env over YAML", cfg.App.PollInterval, 2 * time.Minute},
+        {".env over YAML string", cfg.Logging.Level, "debug"},
+        {".env only", cfg.Database.Password, "from-dotenv"},
+        {"YAML over default", cfg.App.HeartbeatInterval, 15 * time.Second},
+        {"default", cfg.App.CommandTimeout, 60 * time.Second},
+        {"default kept by YAML", cfg.App.MaxRetries, 3},
+    }
+    for _, tt := range tests {
+        if tt.got != tt.want {
+            t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+        }
+    }
+}
+
+func TestLoadMissingLayers(t *testing.T) {
+    layers(t, "", "")
+    t.Setenv("ENV_FILE", filepath.Join(t.TempDir(), "missing.env"))
+    t.Setenv("DB_PASSWORD", "from-env")
+
+    cfg, err := Load()
+    if err != nil {
+        t.Fatalf("Load() error = %v, want missing files skipped", err)
+    }
+    if cfg.App.MaxConcurrentChecks != defaults().App.MaxConcurrentChecks {
+        t.Errorf("MaxConcurrentChecks = %d, want the default", cfg.App.MaxConcurrentChecks)
+    }
+}
+
+func TestLoadErrors(t *testing.T) {
+    t.Run("no DB password", func(t *testing.T) {
+        layers(t, "", "DB_HOST=db.example\n")
+        if _, err := Load(); err == nil {
+            t.Error("Load() succeeded without DB_PASSWORD")
+        }
+    })
+
+    t.Run("malformed YAML", func(t *testing.T) {
+        layers(t, "health_check: [\n", "DB_PASSWORD=x\n")
+        if _, err := Load(); err == nil {
+            t.Error("Load() succeeded with malformed YAML")
+        }
+    })
+
+    t.Run("invalid values keep the lower layer", func(t *testing.T) {
+        layers(t, "health_check:\n  max_concurrent_checks: 10\n", "DB_PASSWORD=x\nMAX_CONCURRENT_CHECKS=many\n")
+        cfg, err := Load()
+        if err != nil {
+            t.Fatalf("Load() error = %v", err)
+        }
+        if cfg.App.MaxConcurrentChecks != 10 {
+            t.Errorf("MaxConcurrentChecks = %d, want 10 from YAML", cfg.App.MaxConcurrentChecks)
+        }
+    })
+}
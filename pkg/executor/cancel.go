@@ -0,0 +1,103 @@
+package executor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+)
+
+// CancelError is the cause of a check cancelled through Cancel
+type CancelError struct {
+    Reason string
+    By     string
+}
+
+func (e *CancelError) Error() string {
+    if e.Reason == "" {
+        return "cancelled by " + e.By
+    }
+    return fmt.Sprintf("cancelled by %s: %s", e.By, e.Reason)
+}
+
+// runningCheck is a check running in this process
+type runningCheck struct {
+    neID   string
+    cancel context.CancelCauseFunc
+}
+
+func (e *Executor) track(sessionID, neID string, cancel context.CancelCauseFunc) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.running[sessionID] = &runningCheck{neID: neID, cancel: cancel}
+}
+
+func (e *Executor) untrack(sessionID string) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    delete(e.running, sessionID)
+}
+
+// Cancel aborts a check running in this process. target is the node's
+// neId or the check's session ID. The check closes its SSH session,
+// releases its user and moves the node to cancelled. It reports whether
+// a running check was found.
+func (e *Executor) Cancel(target, reason, by string) bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+
+    for sessionID, c := range e.running {
+        if sessionID == target || c.neID == target {
+            log.Printf("Cancelling check %s on %s (by %s)", sessionID, c.neID, by)
+            c.cancel(&CancelError{Reason: reason, By: by})
+            return true
+        }
+    }
+    return false
+}
+
+// WatchCancels cancels the checks of this process that were requested
+// through status.Manager.RequestCancel, e.g. by another process, checking
+// every interval until ctx is done
+func (e *Executor) WatchCancels(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            e.mu.Lock()
+            sessionIDs := make([]string, 0, len(e.running))
+            for id := range e.running {
+                sessionIDs = append(sessionIDs, id)
+            }
+            e.mu.Unlock()
+
+            requests, err := e.mgr.Status.GetCancelRequests(sessionIDs)
+            if err != nil {
+                log.Printf("Failed to get cancel requests: %v", err)
+                continue
+            }
+            for _, r := range requests {
+                e.Cancel(r.SessionID, r.Reason, r.RequestedBy)
+            }
+        }
+    }
+}
+
+// shutdownCancel is recorded for checks interrupted by the process shutting
+// down, so that a restart is not counted as a failure of every node being
+// checked
+var shutdownCancel = &CancelError{Reason: "shutdown", By: "system"}
+
+// cancelled returns why ctx was cancelled if that was done through Cancel
+func cancelled(ctx context.Context) *CancelError {
+    var ce *CancelError
+    if errors.As(context.Cause(ctx), &ce) {
+        return ce
+    }
+    return nil
+}
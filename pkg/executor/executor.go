@@ -0,0 +1,511 @@
+package executor
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "health-check-system/pkg/appserver"
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/logging"
+    "health-check-system/pkg/parser"
+    "health-check-system/pkg/profile"
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/scoring"
+    "health-check-system/pkg/session"
+    "health-check-system/pkg/status"
+    "health-check-system/pkg/tracker"
+    "health-check-system/pkg/userpool"
+)
+
+// Config holds executor settings
+type Config struct {
+    MaxConcurrentChecks int
+    MaxWait             time.Duration
+    CommandTimeout      time.Duration
+    HeartbeatInterval   time.Duration
+    Retry               RetryPolicy
+}
+
+// Managers are the components an executor drives
+type Managers struct {
+    Inventory *inventory.Manager
+    Users     *userpool.Pool
+    Proxies   *proxy.Manager
+    Servers   *appserver.Manager
+    Status    *status.Manager
+    Sessions  *session.Manager
+    Profiles  *profile.Registry
+    History   *history.Recorder
+    Scoring   *scoring.Engine
+    Tracker   *tracker.Tracker
+}
+
+// Executor runs health checks with a bounded worker pool
+type Executor struct {
+    cfg Config
+    mgr Managers
+
+    slots chan struct{}
+    wg    sync.WaitGroup
+
+    mu      sync.Mutex
+    running map[string]*runningCheck
+}
+
+// New creates a new executor
+func New(cfg Config, managers Managers) *Executor {
+    if cfg.MaxConcurrentChecks <= 0 {
+        cfg.MaxConcurrentChecks = 1
+    }
+    if cfg.CommandTimeout <= 0 {
+        cfg.CommandTimeout = 60 * time.Second
+    }
+    if cfg.HeartbeatInterval <= 0 {
+        cfg.HeartbeatInterval = 30 * time.Second
+    }
+    if cfg.Retry.Delay <= 0 {
+        cfg.Retry.Delay = 10 * time.Second
+    }
+    if cfg.Retry.RetryOn == nil {
+        cfg.Retry.RetryOn = DefaultRetryOn
+    }
+
+    return &Executor{
+        cfg:     cfg,
+        mgr:     managers,
+        slots:   make(chan struct{}, cfg.MaxConcurrentChecks),
+        running: make(map[string]*runningCheck),
+    }
+}
+
+// RunOnce fills the free worker slots with nodes due for a check and
+// returns the number of checks started. It does not wait for them.
+func (e *Executor) RunOnce(ctx context.Context) (int, error) {
+    free := cap(e.slots) - len(e.slots)
+    if free == 0 {
+        return 0, nil
+    }
+
+    nodes, err := e.mgr.Inventory.GetNodesToCheck(free)
+    if errors.Is(err, inventory.ErrNoNodes) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, fmt.Errorf("failed to get nodes: %w", err)
+    }
+
+    started := 0
+    for _, node := range nodes {
+        select {
+        case e.slots <- struct{}{}:
+        default:
+            return started, nil
+        }
+
+        sessionID := newSessionID()
+
+        // Queue synchronously so the next RunOnce does not pick the node again
+        if err := e.mgr.Status.UpdateStatus(node.NeID, status.StatusQueued, sessionID, ""); err != nil {
+            <-e.slots
+            log.Printf("Failed to queue %s: %v", node.NeID, err)
+            continue
+        }
+
+        e.wg.Add(1)
+        go func(node *inventory.Node) {
+            defer e.wg.Done()
+            defer func() { <-e.slots }()
+            e.check(ctx, node, sessionID)
+        }(node)
+        started++
+    }
+
+    return started, nil
+}
+
+// Wait blocks until all running checks have finished
+func (e *Executor) Wait() {
+    e.wg.Wait()
+}
+
+// Active returns the number of checks running in this process
+func (e *Executor) Active() int {
+    return len(e.slots)
+}
+
+// check drives one node through the status states
+func (e *Executor) check(ctx context.Context, node *inventory.Node, sessionID string) {
+    start := time.Now()
+
+    // parent is done when the process shuts down
+    parent := ctx
+    ctx, cancel := context.WithCancelCause(ctx)
+    defer cancel(nil)
+    e.track(sessionID, node.NeID, cancel)
+    defer func() {
+        e.untrack(sessionID)
+        if err := e.mgr.Status.ClearCancelRequest(sessionID); err != nil {
+            log.Printf("Failed to clear cancel request for %s: %v", sessionID, err)
+        }
+    }()
+
+    if e.cfg.MaxWait > 0 {
+        var cancel context.CancelFunc
+        ctx, cancel = context.WithTimeout(ctx, e.cfg.MaxWait)
+        defer cancel()
+    }
+
+    err := e.mgr.History.Start(history.Entry{
+        SessionID: sessionID,
+        NeID:      node.NeID,
+        NodeIP:    node.IPAddress,
+        Hostname:  node.Hostname,
+        Circle:    node.Circle,
+        StartedAt: start,
+    })
+    if err != nil {
+        log.Printf("Failed to start check for %s: %v", node.NeID, err)
+        if err := e.mgr.Status.RecordCompletion(node.NeID, sessionID, false, 0, err.Error()); err != nil {
+            log.Printf("Failed to record completion for %s: %v", node.NeID, err)
+        }
+        return
+    }
+    e.progress(sessionID, node.NeID, status.StatusQueued, "Check queued", 0)
+
+    outputs, err := e.run(ctx, node, sessionID)
+
+    retries := 0
+    for ctx.Err() == nil && e.cfg.Retry.retryable(err, retries) {
+        retries++
+        delay := e.cfg.Retry.backoff(retries)
+        log.Printf("Check of %s failed (%s), retry %d/%d in %s: %v",
+            node.NeID, classify(err), retries, e.cfg.Retry.MaxRetries, delay, err)
+
+        if serr := e.mgr.Status.Retry(node.NeID, sessionID, retries, err.Error()); serr != nil {
+            log.Printf("Failed to requeue %s: %v", node.NeID, serr)
+            break
+        }
+        e.progress(sessionID, node.NeID, status.StatusQueued,
+            fmt.Sprintf("Retry %d/%d in %s: %v", retries, e.cfg.Retry.MaxRetries, delay, err), 0)
+
+        select {
+        case <-ctx.Done():
+            outputs, err = nil, ctx.Err()
+        case <-time.After(delay):
+            outputs, err = e.run(ctx, node, sessionID)
+        }
+    }
+
+    res := history.Result{RetryCount: retries}
+    switch {
+    case outputs != nil && err == nil:
+        metrics := parser.Parse(outputs)
+        score := e.mgr.Scoring.Score(metrics)
+        res.HealthScore = &score.Score
+        res.Metrics = metricsDocument{Metrics: metrics, ScoreBreakdown: score.Breakdown}
+
+        if err := e.mgr.Status.RecordScore(node.NeID, score.Score); err != nil {
+            log.Printf("Failed to record health score for %s: %v", node.NeID, err)
+        }
+    case outputs != nil:
+        // Partial output of an aborted check is kept for diagnosis but
+        // not scored, so the node's last score stays in place
+        res.Metrics = metricsDocument{Metrics: parser.Parse(outputs)}
+    }
+    res.Duration = int(time.Since(start).Seconds())
+
+    final := status.StatusCompleted
+    cancelErr := cancelled(ctx)
+    if cancelErr == nil && err != nil && parent.Err() != nil {
+        cancelErr = shutdownCancel
+    }
+    switch {
+    case err != nil && cancelErr != nil:
+        final, res.Result, res.Error = status.StatusCancelled, "cancelled", cancelErr.Error()
+        res.CancelReason, res.CancelledBy = cancelErr.Reason, cancelErr.By
+        err = e.mgr.Status.RecordCancelled(node.NeID, sessionID, res.Duration, res.Error)
+    case err != nil && errors.Is(err, context.DeadlineExceeded):
+        final, res.Result, res.Error = status.StatusTimeout, "timeout", err.Error()
+        err = e.mgr.Status.RecordTimeout(node.NeID, sessionID, res.Duration, res.Error)
+    case err != nil:
+        final, res.Result, res.Error = status.StatusFailed, "failed", err.Error()
+        err = e.mgr.Status.RecordCompletion(node.NeID, sessionID, false, res.Duration, res.Error)
+    default:
+        res.Result, res.Error = "success", commandErrors(outputs)
+        if res.Error != "" {
+            final, res.Result = status.StatusFailed, "failed"
+        }
+        err = e.mgr.Status.RecordCompletion(node.NeID, sessionID, res.Error == "", res.Duration, res.Error)
+    }
+    if err != nil {
+        log.Printf("Failed to record completion for %s: %v", node.NeID, err)
+    }
+
+    res.FinalStatus = string(final)
+    if err := e.mgr.History.Finish(sessionID, res); err != nil {
+        log.Printf("Failed to finish history for %s: %v", node.NeID, err)
+    }
+
+    msg := "Check " + res.Result
+    if res.Error != "" {
+        msg += ": " + res.Error
+    }
+    e.progress(sessionID, node.NeID, final, msg, 100)
+}
+
+// metricsDocument is what is stored in hc_history.metrics
+type metricsDocument struct {
+    *parser.Metrics
+    ScoreBreakdown []scoring.RuleResult `json:"score_breakdown,omitempty"`
+}
+
+// run acquires a user and proxy, connects and runs the commands
+func (e *Executor) run(ctx context.Context, node *inventory.Node, sessionID string) (_ []parser.Output, err error) {
+    commands, err := e.mgr.Profiles.CommandsFor(node)
+    if err != nil {
+        return nil, err
+    }
+
+    user, err := e.mgr.Users.AcquireUser(ctx, sessionID)
+    if err != nil {
+        return nil, withClass(ClassResource, fmt.Errorf("failed to acquire user: %w", err))
+    }
+    defer func() {
+        if err := e.mgr.Users.ReleaseUser(user.Username, sessionID); err != nil {
+            log.Printf("Failed to release user %s: %v", user.Username, err)
+        }
+    }()
+
+    // Once the lease is lost, the user may already serve another check, so
+    // this one is aborted
+    ctx, abort := context.WithCancelCause(ctx)
+    defer abort(nil)
+    go func() {
+        if err := e.mgr.Users.KeepAlive(ctx, sessionID, e.cfg.HeartbeatInterval); err != nil {
+            log.Printf("Aborting check of %s: %v", node.NeID, err)
+            abort(err)
+        }
+    }()
+    defer func() {
+        if cause := context.Cause(ctx); err != nil && errors.Is(cause, userpool.ErrLeaseLost) {
+            err = cause
+        }
+    }()
+
+    if err := e.mgr.Status.UpdateStatus(node.NeID, status.StatusConnecting, sessionID, user.Username); err != nil {
+        return nil, err
+    }
+
+    server, px, sess, err := e.connect(ctx, node, user, sessionID)
+    if err != nil {
+        return nil, err
+    }
+    defer sess.Close()
+
+    // Once registered, the proxy connection and app server load belong to
+    // the session and are released by Unregister, whether here or by the
+    // reaper or sweeper
+    err = e.mgr.Tracker.Register(&tracker.ActiveSession{
+        SessionID: sessionID,
+        Username:  user.Username,
+        NeID:      node.NeID,
+        NodeIP:    node.IPAddress,
+        MitoProxy: px.Name,
+        AppServer: server.Name,
+    })
+    if err != nil {
+        if err := e.mgr.Proxies.Release(px.Name); err != nil {
+            log.Printf("Failed to release proxy %s: %v", px.Name, err)
+        }
+        e.releaseServer(server)
+        return nil, err
+    }
+    defer func() {
+        if err := e.mgr.Tracker.Unregister(sessionID); err != nil {
+            log.Printf("Failed to unregister session %s: %v", sessionID, err)
+        }
+    }()
+
+    hbCtx, stopHeartbeat := context.WithCancel(ctx)
+    defer stopHeartbeat()
+    go e.mgr.Tracker.KeepAlive(hbCtx, sessionID, e.cfg.HeartbeatInterval)
+
+    if err := e.mgr.Status.UpdateStatus(node.NeID, status.StatusRunning, sessionID, user.Username); err != nil {
+        return nil, err
+    }
+    e.progress(sessionID, node.NeID, status.StatusRunning,
+        fmt.Sprintf("Running %d commands", len(commands)), 30)
+
+    results := make([]parser.Output, len(commands))
+    cmds := make([]*session.Command, len(commands))
+    for i, c := range commands {
+        results[i].Name = c.Name
+        results[i].Command = c.Command
+        results[i].Parser = c.Parser
+        cmds[i], results[i].Err = sess.Start(c.Command)
+    }
+
+    if err := e.mgr.Status.UpdateStatus(node.NeID, status.StatusPolling, sessionID, user.Username); err != nil {
+        return nil, err
+    }
+
+    for i, cmd := range cmds {
+        if cmd == nil {
+            continue
+        }
+        cmdCtx, cancel := context.WithTimeout(ctx, e.cfg.CommandTimeout)
+        results[i].Output, results[i].Err = cmd.Wait(cmdCtx)
+        cancel()
+
+        if ctx.Err() != nil {
+            return results, ctx.Err()
+        }
+        e.progress(sessionID, node.NeID, status.StatusPolling,
+            fmt.Sprintf("Collected %s", results[i].Name), 30+60*(i+1)/len(cmds))
+    }
+
+    if err := e.mgr.Status.UpdateStatus(node.NeID, status.StatusCollecting, sessionID, user.Username); err != nil {
+        return nil, err
+    }
+    e.progress(sessionID, node.NeID, status.StatusCollecting, "Parsing output", 95)
+
+    return results, nil
+}
+
+// connect opens a session on behalf of an app server. The SSH chain is
+// dialled from this process, so the app server only carries the load of
+// the check and is not failed over. Until the session is registered, the
+// app server must be given back with releaseServer.
+func (e *Executor) connect(ctx context.Context, node *inventory.Node, user *userpool.User, sessionID string) (*appserver.Server, *proxy.Proxy, *session.Session, error) {
+    server, err := e.mgr.Servers.Acquire()
+    if err != nil {
+        return nil, nil, nil, withClass(ClassResource, err)
+    }
+
+    var sess *session.Session
+    px, err := e.mgr.Proxies.Connect(ctx, func(ctx context.Context, px *proxy.Proxy) error {
+        if err := e.mgr.History.SetConnection(sessionID, user.Username, px.Name, server.Name); err != nil {
+            log.Printf("Failed to record connection for %s: %v", node.NeID, err)
+        }
+        e.progress(sessionID, node.NeID, status.StatusConnecting,
+            fmt.Sprintf("Connecting as %s via %s", user.Username, px.Name), 10)
+
+        // Connect already retries across proxies and passes, so each
+        // proxy gets a single attempt
+        var err error
+        sess, err = e.mgr.Sessions.OpenOnce(ctx, px, user, node)
+        return proxyError(err)
+    })
+    e.recordAuth(user, err)
+    e.recordServer(server, err)
+    if err != nil {
+        e.releaseServer(server)
+        return nil, nil, nil, err
+    }
+
+    return server, px, sess, nil
+}
+
+func (e *Executor) releaseServer(server *appserver.Server) {
+    if err := e.mgr.Servers.Release(server.Name); err != nil {
+        log.Printf("Failed to release app server %s: %v", server.Name, err)
+    }
+}
+
+// progress adds a live update for the session
+func (e *Executor) progress(sessionID, neID string, st status.Status, message string, percent int) {
+    logging.Debugf("%s [%s] %s: %s (%d%%)", neID, sessionID, st, message, percent)
+    if err := e.mgr.Status.AddLiveUpdate(sessionID, neID, string(st), message, percent); err != nil {
+        log.Printf("Failed to add live update for %s: %v", neID, err)
+    }
+}
+
+// proxyError marks session errors past the proxy hop as downstream so
+// that proxy failover only moves on when the proxy itself failed
+func proxyError(openErr error) error {
+    var hopErr *session.HopError
+    if openErr == nil || (errors.As(openErr, &hopErr) && hopErr.Hop == session.HopProxy) {
+        return openErr
+    }
+    return proxy.Downstream(openErr)
+}
+
+// recordAuth records whether the NIAM user's credentials were accepted.
+// Errors before the NIAM hop say nothing about them.
+func (e *Executor) recordAuth(user *userpool.User, openErr error) {
+    var hopErr *session.HopError
+    var err error
+    switch {
+    case openErr == nil:
+        err = e.mgr.Users.RecordAuthSuccess(user.Username)
+    case errors.As(openErr, &hopErr) && hopErr.Hop != session.HopProxy && session.IsAuthFailure(hopErr):
+        log.Printf("NIAM user %s rejected by %s %s", user.Username, hopErr.Hop, hopErr.Addr)
+        err = e.mgr.Users.RecordAuthFailure(user.Username)
+    }
+    if err != nil {
+        log.Printf("Failed to record auth result for %s: %v", user.Username, err)
+    }
+}
+
+// recordServer records whether a connection made on behalf of the app
+// server worked. Only reaching a proxy counts; NIAM, node, credential and
+// cancellation errors are not counted against it.
+func (e *Executor) recordServer(server *appserver.Server, openErr error) {
+    var hopErr *session.HopError
+    var err error
+    switch {
+    case openErr == nil, errors.As(openErr, &hopErr) && hopErr.Hop != session.HopProxy:
+        err = e.mgr.Servers.RecordSuccess(server.Name)
+    case serverError(openErr):
+        err = e.mgr.Servers.RecordFailure(server.Name)
+    }
+    if err != nil {
+        log.Printf("Failed to record app server result for %s: %v", server.Name, err)
+    }
+}
+
+// serverError reports whether a failed connection points at the app
+// server: every attempted proxy failed on the proxy hop for a reason other
+// than rejected credentials
+func serverError(err error) bool {
+    var failover *proxy.FailoverError
+    if !errors.As(err, &failover) || failover.Err != nil || len(failover.Attempts) == 0 {
+        return false
+    }
+    for _, a := range failover.Attempts {
+        var hopErr *session.HopError
+        if !errors.As(a.Err, &hopErr) || hopErr.Hop != session.HopProxy || session.IsAuthFailure(hopErr) {
+            return false
+        }
+    }
+    return true
+}
+
+// commandErrors joins the errors of failed commands
+func commandErrors(results []parser.Output) string {
+    msg := ""
+    for _, r := range results {
+        if r.Err == nil {
+            continue
+        }
+        if msg != "" {
+            msg += "; "
+        }
+        msg += r.Err.Error()
+    }
+    return msg
+}
+
+// newSessionID returns a unique health check session ID
+func newSessionID() string {
+    b := make([]byte, 4)
+    rand.Read(b)
+    return fmt.Sprintf("hc-%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(b))
+}
@@ -0,0 +1,143 @@
+package executor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math/rand"
+    "strings"
+    "time"
+
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/session"
+)
+
+// ErrorClass groups check errors for the retry policy
+type ErrorClass string
+
+const (
+    ClassProxy     ErrorClass = "proxy"     // no proxy could be reached
+    ClassConnect   ErrorClass = "connect"   // NIAM or node hop failed
+    ClassAuth      ErrorClass = "auth"      // credentials rejected
+    ClassResource  ErrorClass = "resource"  // no NIAM user, proxy or app server free
+    ClassTimeout   ErrorClass = "timeout"   // max wait exceeded
+    ClassCancelled ErrorClass = "cancelled" // check was cancelled
+    ClassOther     ErrorClass = "other"
+)
+
+// RetryPolicy decides which failed checks are attempted again
+type RetryPolicy struct {
+    MaxRetries int
+
+    // Delay before the first retry, doubled for every further retry up
+    // to MaxDelay. Each wait is jittered down to half of it, so checks
+    // that failed together do not retry in lockstep.
+    Delay    time.Duration
+    MaxDelay time.Duration
+
+    // RetryOn lists the error classes that are retried
+    RetryOn []ErrorClass
+}
+
+// DefaultRetryOn are the error classes retried when none are configured.
+// Auth errors are never retried by default, to avoid locking accounts.
+// Proxy errors are left out as proxy.Manager.Connect has already made its
+// passes over every proxy.
+var DefaultRetryOn = []ErrorClass{ClassConnect, ClassResource}
+
+// ParseErrorClasses converts configured class names. A nil list means
+// DefaultRetryOn; an empty list disables retries.
+func ParseErrorClasses(names []string) ([]ErrorClass, error) {
+    if names == nil {
+        return nil, nil
+    }
+
+    classes := make([]ErrorClass, 0, len(names))
+    for _, name := range names {
+        c := ErrorClass(strings.ToLower(strings.TrimSpace(name)))
+        switch c {
+        case ClassProxy, ClassConnect, ClassAuth, ClassResource, ClassTimeout, ClassCancelled, ClassOther:
+            classes = append(classes, c)
+        default:
+            return nil, fmt.Errorf("unknown error class %q", name)
+        }
+    }
+    return classes, nil
+}
+
+// retryable reports whether a check that failed with err after retries
+// retries should be attempted again
+func (p RetryPolicy) retryable(err error, retries int) bool {
+    if err == nil || retries >= p.MaxRetries {
+        return false
+    }
+
+    class := classify(err)
+    for _, c := range p.RetryOn {
+        if c == class {
+            return true
+        }
+    }
+    return false
+}
+
+// backoff returns the delay before retry number retry (starting at 1),
+// between half and all of the doubled and capped delay
+func (p RetryPolicy) backoff(retry int) time.Duration {
+    delay := p.Delay
+    for i := 1; i < retry; i++ {
+        delay *= 2
+        if p.MaxDelay > 0 && delay >= p.MaxDelay {
+            delay = p.MaxDelay
+            break
+        }
+    }
+    if p.MaxDelay > 0 && delay > p.MaxDelay {
+        delay = p.MaxDelay
+    }
+    if delay <= 1 {
+        return delay
+    }
+    half := delay / 2
+    return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+// classError tags an error with its class where the class cannot be
+// derived from the error itself
+type classError struct {
+    class ErrorClass
+    err   error
+}
+
+func (e *classError) Error() string { return e.err.Error() }
+
+func (e *classError) Unwrap() error { return e.err }
+
+func withClass(class ErrorClass, err error) error {
+    return &classError{class: class, err: err}
+}
+
+// classify returns the error class of a failed check
+func classify(err error) ErrorClass {
+    var ce *classError
+    var hopErr *session.HopError
+    var failover *proxy.FailoverError
+
+    switch {
+    case errors.As(err, &ce):
+        return ce.class
+    case errors.Is(err, context.DeadlineExceeded):
+        return ClassTimeout
+    case errors.Is(err, context.Canceled):
+        return ClassCancelled
+    case session.IsAuthFailure(err):
+        return ClassAuth
+    case errors.As(err, &hopErr) && hopErr.Hop == session.HopProxy:
+        return ClassProxy
+    case errors.As(err, &hopErr):
+        return ClassConnect
+    case errors.As(err, &failover):
+        return ClassProxy
+    }
+    return ClassOther
+}
@@ -0,0 +1,142 @@
+package executor
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "reflect"
+    "testing"
+    "time"
+
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/session"
+)
+
+func TestBackoff(t *testing.T) {
+    tests := []struct {
+        name   string
+        policy RetryPolicy
+        retry  int
+        want   time.Duration // upper bound; the jitter goes down to half
+    }{
+        {"first retry", RetryPolicy{Delay: 10 * time.Second, MaxDelay: 5 * time.Minute}, 1, 10 * time.Second},
+        {"doubled", RetryPolicy{Delay: 10 * time.Second, MaxDelay: 5 * time.Minute}, 3, 40 * time.Second},
+        {"capped", RetryPolicy{Delay: 10 * time.Second, MaxDelay: 5 * time.Minute}, 6, 5 * time.Minute},
+        {"capped far out", RetryPolicy{Delay: 10 * time.Second, MaxDelay: 5 * time.Minute}, 200, 5 * time.Minute},
+        {"delay above cap", RetryPolicy{Delay: 10 * time.Minute, MaxDelay: 5 * time.Minute}, 1, 5 * time.Minute},
+        {"no cap", RetryPolicy{Delay: time.Second}, 5, 16 * time.Second},
+        {"no delay", RetryPolicy{}, 3, 0},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            lo, hi := tt.want, time.Duration(0)
+            for i := 0; i < 1000; i++ {
+                got := tt.policy.backoff(tt.retry)
+                if got < tt.want/2 || got > tt.want {
+                    t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.retry, got, tt.want/2, tt.want)
+                }
+                if got < lo {
+                    lo = got
+                }
+                if got > hi {
+                    hi = got
+                }
+            }
+            if tt.want >= time.Second && lo == hi {
+                t.Errorf("backoff(%d) always returned %s, want jitter", tt.retry, lo)
+            }
+        })
+    }
+}
+
+func TestClassify(t *testing.T) {
+    proxyHop := &session.HopError{Hop: session.HopProxy, Addr: "10.0.0.1:22", Err: errors.New("connection refused")}
+    niamHop := &session.HopError{Hop: session.HopNiam, Addr: "10.0.0.2:2222", Err: errors.New("connection refused")}
+    authHop := &session.HopError{Hop: session.HopNiam, Addr: "10.0.0.2:2222", Err: session.ErrAuthFailed}
+
+    tests := []struct {
+        name string
+        err  error
+        want ErrorClass
+    }{
+        {"tagged", withClass(ClassResource, errors.New("no NIAM user free")), ClassResource},
+        {"tag wins over cause", withClass(ClassResource, niamHop), ClassResource},
+        {"deadline", fmt.Errorf("check: %w", context.DeadlineExceeded), ClassTimeout},
+        {"cancelled", context.Canceled, ClassCancelled},
+        {"auth", authHop, ClassAuth},
+        {"proxy hop", proxyHop, ClassProxy},
+        {"niam hop", niamHop, ClassConnect},
+        {"node hop", &session.HopError{Hop: session.HopNode, Addr: "10.0.0.3:22", Err: errors.New("timeout")}, ClassConnect},
+        {"all proxies failed", &proxy.FailoverError{Attempts: []proxy.Attempt{{Proxy: "mito-1", Pass: 1, Err: errors.New("breaker open")}}}, ClassProxy},
+        {"failover through proxy hop", &proxy.FailoverError{Attempts: []proxy.Attempt{{Proxy: "mito-1", Pass: 1, Err: proxyHop}}}, ClassProxy},
+        {"other", errors.New("parse failed"), ClassOther},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := classify(tt.err); got != tt.want {
+                t.Errorf("classify(%v) = %s, want %s", tt.err, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestRetryable(t *testing.T) {
+    policy := RetryPolicy{MaxRetries: 2, RetryOn: DefaultRetryOn}
+    niamHop := &session.HopError{Hop: session.HopNiam, Err: errors.New("connection refused")}
+
+    tests := []struct {
+        name    string
+        policy  RetryPolicy
+        err     error
+        retries int
+        want    bool
+    }{
+        {"no error", policy, nil, 0, false},
+        {"connect", policy, niamHop, 0, true},
+        {"resource", policy, withClass(ClassResource, errors.New("no app server")), 1, true},
+        {"retries used up", policy, niamHop, 2, false},
+        {"auth", policy, &session.HopError{Hop: session.HopNiam, Err: session.ErrAuthFailed}, 0, false},
+        {"proxy not in defaults", policy, &proxy.FailoverError{}, 0, false},
+        {"timeout", policy, context.DeadlineExceeded, 0, false},
+        {"cancelled", policy, context.Canceled, 0, false},
+        {"proxy configured", RetryPolicy{MaxRetries: 1, RetryOn: []ErrorClass{ClassProxy}}, &proxy.FailoverError{}, 0, true},
+        {"retries disabled", RetryPolicy{MaxRetries: 3, RetryOn: []ErrorClass{}}, niamHop, 0, false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.policy.retryable(tt.err, tt.retries); got != tt.want {
+                t.Errorf("retryable(%v, %d) = %v, want %v", tt.err, tt.retries, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestParseErrorClasses(t *testing.T) {
+    tests := []struct {
+        name    string
+        names   []string
+        want    []ErrorClass
+        wantErr bool
+    }{
+        {"nil means default", nil, nil, false},
+        {"empty disables", []string{}, []ErrorClass{}, false},
+        {"classes", []string{"proxy", "connect"}, []ErrorClass{ClassProxy, ClassConnect}, false},
+        {"case and space", []string{" Resource ", "AUTH"}, []ErrorClass{ClassResource, ClassAuth}, false},
+        {"unknown", []string{"connect", "network"}, nil, true},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := ParseErrorClasses(tt.names)
+            if (err != nil) != tt.wantErr {
+                t.Fatalf("ParseErrorClasses(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+            }
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("ParseErrorClasses(%q) = %v, want %v", tt.names, got, tt.want)
+            }
+        })
+    }
+}
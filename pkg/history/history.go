@@ -0,0 +1,113 @@
+package history
+
+import (
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// Entry describes a health check session when it starts
+type Entry struct {
+    SessionID string
+    NeID      string
+    NodeIP    string
+    Hostname  string
+    Circle    string
+    Username  string
+    MitoProxy string
+    AppServer string
+    StartedAt time.Time
+}
+
+// Result describes how a health check session ended
+type Result struct {
+    FinalStatus string
+    Result      string
+    Duration    int
+    HealthScore *int
+    Metrics     interface{}
+    Error       string
+    RetryCount  int
+
+    // CancelReason and CancelledBy are set for cancelled checks
+    CancelReason string
+    CancelledBy  string
+}
+
+// Recorder writes health check sessions to hc_history
+type Recorder struct {
+    db *sql.DB
+}
+
+// NewRecorder creates a new history recorder
+func NewRecorder(db *sql.DB) *Recorder {
+    return &Recorder{
+        db: db,
+    }
+}
+
+// Start creates the history row of a session. It must be called before
+// any live update is added for the session.
+func (r *Recorder) Start(e Entry) error {
+    _, err := r.db.Exec(`
+        INSERT INTO hc_history (
+            session_id, neId, node_ip, hostname, circle,
+            username, mito_proxy_used, app_server_used, started_at
+        )
+        VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), ?)
+    `, e.SessionID, e.NeID, e.NodeIP, e.Hostname, e.Circle,
+        e.Username, e.MitoProxy, e.AppServer, e.StartedAt)
+
+    if err != nil {
+        return fmt.Errorf("failed to start history for %s: %w", e.SessionID, err)
+    }
+    return nil
+}
+
+// SetConnection records the user, proxy and app server of a session once
+// they are known. Empty values leave the stored ones unchanged.
+func (r *Recorder) SetConnection(sessionID, username, mitoProxy, appServer string) error {
+    _, err := r.db.Exec(`
+        UPDATE hc_history
+        SET username = COALESCE(NULLIF(?, ''), username),
+            mito_proxy_used = COALESCE(NULLIF(?, ''), mito_proxy_used),
+            app_server_used = COALESCE(NULLIF(?, ''), app_server_used)
+        WHERE session_id = ?
+    `, username, mitoProxy, appServer, sessionID)
+
+    return err
+}
+
+// Finish finalizes the history row of a session
+func (r *Recorder) Finish(sessionID string, res Result) error {
+    var metrics interface{}
+    if res.Metrics != nil {
+        data, err := json.Marshal(res.Metrics)
+        if err != nil {
+            return fmt.Errorf("failed to encode metrics: %w", err)
+        }
+        metrics = string(data)
+    }
+
+    _, err := r.db.Exec(`
+        UPDATE hc_history
+        SET completed_at = NOW(),
+            duration = ?,
+            final_status = ?,
+            result = ?,
+            health_score = ?,
+            metrics = COALESCE(?, metrics),
+            error_message = NULLIF(?, ''),
+            retry_count = GREATEST(retry_count, ?),
+            cancel_reason = NULLIF(?, ''),
+            cancelled_by = NULLIF(?, '')
+        WHERE session_id = ?
+    `, res.Duration, res.FinalStatus, res.Result, res.HealthScore, metrics, res.Error, res.RetryCount,
+        res.CancelReason, res.CancelledBy, sessionID)
+
+    if err != nil {
+        return fmt.Errorf("failed to finish history for %s: %w", sessionID, err)
+    }
+    return nil
+}
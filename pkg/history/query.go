@@ -0,0 +1,148 @@
+package history
+
+import (
+    "database/sql"
+    "encoding/json"
+    "time"
+)
+
+// Record is a stored health check session
+type Record struct {
+    SessionID    string          `json:"session_id"`
+    NeID         string          `json:"neId"`
+    NodeIP       string          `json:"node_ip"`
+    Hostname     string          `json:"hostname"`
+    Circle       string          `json:"circle"`
+    Username     string          `json:"username,omitempty"`
+    MitoProxy    string          `json:"mito_proxy_used,omitempty"`
+    AppServer    string          `json:"app_server_used,omitempty"`
+    StartedAt    time.Time       `json:"started_at"`
+    CompletedAt  *time.Time      `json:"completed_at"`
+    Duration     *int            `json:"duration"`
+    FinalStatus  string          `json:"final_status,omitempty"`
+    Result       string          `json:"result,omitempty"`
+    HealthScore  *int            `json:"health_score"`
+    Error        string          `json:"error_message,omitempty"`
+    RetryCount   int             `json:"retry_count"`
+    CancelReason string          `json:"cancel_reason,omitempty"`
+    CancelledBy  string          `json:"cancelled_by,omitempty"`
+    Metrics      json.RawMessage `json:"metrics,omitempty"`
+}
+
+// Filter selects sessions in Query. Empty fields match every session.
+type Filter struct {
+    NeID        string
+    Circle      string
+    FinalStatus string
+    Result      string
+    Since       time.Time
+    Until       time.Time
+}
+
+const recordColumns = `
+    session_id, neId, COALESCE(node_ip, ''), COALESCE(hostname, ''), COALESCE(circle, ''),
+    COALESCE(username, ''), COALESCE(mito_proxy_used, ''), COALESCE(app_server_used, ''),
+    started_at, completed_at, duration, COALESCE(final_status, ''), COALESCE(result, ''),
+    health_score, COALESCE(error_message, ''), COALESCE(retry_count, 0),
+    COALESCE(cancel_reason, ''), COALESCE(cancelled_by, '')`
+
+// Get returns one session including its metrics. It returns sql.ErrNoRows
+// for unknown sessions.
+func (r *Recorder) Get(sessionID string) (*Record, error) {
+    row := r.db.QueryRow(`
+        SELECT `+recordColumns+`, metrics
+        FROM hc_history
+        WHERE session_id = ?
+    `, sessionID)
+
+    rec := &Record{}
+    var metrics []byte
+    if err := row.Scan(append(rec.fields(), &metrics)...); err != nil {
+        return nil, err
+    }
+    if len(metrics) > 0 {
+        rec.Metrics = metrics
+    }
+
+    return rec, nil
+}
+
+// Query returns one page of the sessions matching f, newest first, and
+// the total number of matching sessions. Metrics are left out; use Get
+// for a single session's metrics.
+func (r *Recorder) Query(f Filter, limit, offset int) ([]*Record, int, error) {
+    where := `
+        FROM hc_history
+        WHERE (? = '' OR neId = ?)
+          AND (? = '' OR circle = ?)
+          AND (? = '' OR final_status = ?)
+          AND (? = '' OR result = ?)
+          AND (? IS NULL OR started_at >= ?)
+          AND (? IS NULL OR started_at < ?)
+    `
+    since, until := nullTime(f.Since), nullTime(f.Until)
+    args := []interface{}{
+        f.NeID, f.NeID,
+        f.Circle, f.Circle,
+        f.FinalStatus, f.FinalStatus,
+        f.Result, f.Result,
+        since, since,
+        until, until,
+    }
+
+    var total int
+    if err := r.db.QueryRow(`SELECT COUNT(*) `+where, args...).Scan(&total); err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := r.db.Query(`
+        SELECT `+recordColumns+`
+        `+where+`
+        ORDER BY started_at DESC, id DESC
+        LIMIT ? OFFSET ?
+    `, append(args, limit, offset)...)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    records := []*Record{}
+    for rows.Next() {
+        rec := &Record{}
+        if err := rows.Scan(rec.fields()...); err != nil {
+            return nil, 0, err
+        }
+        records = append(records, rec)
+    }
+
+    return records, total, rows.Err()
+}
+
+// fields returns the scan targets for recordColumns
+func (rec *Record) fields() []interface{} {
+    return []interface{}{
+        &rec.SessionID,
+        &rec.NeID,
+        &rec.NodeIP,
+        &rec.Hostname,
+        &rec.Circle,
+        &rec.Username,
+        &rec.MitoProxy,
+        &rec.AppServer,
+        &rec.StartedAt,
+        &rec.CompletedAt,
+        &rec.Duration,
+        &rec.FinalStatus,
+        &rec.Result,
+        &rec.HealthScore,
+        &rec.Error,
+        &rec.RetryCount,
+        &rec.CancelReason,
+        &rec.CancelledBy,
+    }
+}
+
+// nullTime maps the zero time to NULL
+func nullTime(t time.Time) sql.NullTime {
+    return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -2,32 +2,48 @@ package inventory
 
 import (
     "database/sql"
+    "errors"
     "fmt"
+    "time"
 )
 
+// ErrNoNodes is returned when no node is due for a health check
+var ErrNoNodes = errors.New("no nodes available for checking")
+
 // Node represents a network node
 type Node struct {
-    NeID       string
-    IPAddress  string
-    Hostname   string
-    Site       string
-    Circle     string
-    Vendor     string
-    NodeType   string
+    NeID      string `json:"neId"`
+    IPAddress string `json:"ip_address"`
+    Hostname  string `json:"hostname"`
+    Site      string `json:"site"`
+    Circle    string `json:"circle"`
+    Vendor    string `json:"vendor"`
+    NodeType  string `json:"node_type"`
+
+    // CustomCommands is the raw hc_nodes.custom_commands JSON, nil when unset
+    CustomCommands []byte `json:"-"`
 }
 
 // Manager manages node inventory
 type Manager struct {
-    db *sql.DB
+    db              *sql.DB
+    recheckInterval time.Duration
 }
 
 // NewManager creates a new inventory manager
 func NewManager(db *sql.DB) *Manager {
     return &Manager{
-        db: db,
+        db:              db,
+        recheckInterval: time.Hour,
     }
 }
 
+// SetRecheckInterval sets how long a finished node waits before it is
+// checked again
+func (m *Manager) SetRecheckInterval(d time.Duration) {
+    m.recheckInterval = d
+}
+
 // GetNodesToCheck returns nodes that need health check
 func (m *Manager) GetNodesToCheck(limit int) ([]*Node, error) {
     query := `
@@ -38,19 +54,22 @@ func (m *Manager) GetNodesToCheck(limit int) ([]*Node, error) {
             n.Site, 
             n.Circle, 
             COALESCE(n.vendor, 'unknown') as vendor,
-            COALESCE(n.node_type, 'router') as node_type
+            COALESCE(n.node_type, 'router') as node_type,
+            n.custom_commands
         FROM hc_nodes n
         JOIN hc_node_status s ON n.neId = s.neId
         WHERE n.Login_status = 'Yes'
           AND n.health_check_enabled = TRUE
-          AND s.current_status = 'idle'
+          AND (s.current_status = 'idle'
+               OR (s.current_status IN ('completed', 'failed', 'timeout', 'cancelled')
+                   AND s.last_check_completed < NOW() - INTERVAL ? SECOND))
         ORDER BY 
             COALESCE(s.last_check_completed, '2000-01-01') ASC,
             n.priority DESC
         LIMIT ?
     `
 
-    rows, err := m.db.Query(query, limit)
+    rows, err := m.db.Query(query, int(m.recheckInterval.Seconds()), limit)
     if err != nil {
         return nil, err
     }
@@ -67,6 +86,7 @@ func (m *Manager) GetNodesToCheck(limit int) ([]*Node, error) {
             &node.Circle,
             &node.Vendor,
             &node.NodeType,
+            &node.CustomCommands,
         )
         if err != nil {
             return nil, err
@@ -75,7 +95,7 @@ func (m *Manager) GetNodesToCheck(limit int) ([]*Node, error) {
     }
 
     if len(nodes) == 0 {
-        return nil, fmt.Errorf("no nodes available for checking")
+        return nil, ErrNoNodes
     }
 
     return nodes, nil
@@ -91,7 +111,8 @@ func (m *Manager) GetNodeByID(neID string) (*Node, error) {
             Site, 
             Circle, 
             COALESCE(vendor, 'unknown') as vendor,
-            COALESCE(node_type, 'router') as node_type
+            COALESCE(node_type, 'router') as node_type,
+            custom_commands
         FROM hc_nodes
         WHERE neId = ? AND Login_status = 'Yes'
     `
@@ -105,6 +126,7 @@ func (m *Manager) GetNodeByID(neID string) (*Node, error) {
         &node.Circle,
         &node.Vendor,
         &node.NodeType,
+        &node.CustomCommands,
     )
     if err != nil {
         return nil, fmt.Errorf("node not found: %w", err)
@@ -123,7 +145,8 @@ func (m *Manager) GetNodesByCircle(circle string, limit int) ([]*Node, error) {
             n.Site, 
             n.Circle, 
             COALESCE(n.vendor, 'unknown') as vendor,
-            COALESCE(n.node_type, 'router') as node_type
+            COALESCE(n.node_type, 'router') as node_type,
+            n.custom_commands
         FROM hc_nodes n
         JOIN hc_node_status s ON n.neId = s.neId
         WHERE n.Circle = ?
@@ -150,6 +173,7 @@ func (m *Manager) GetNodesByCircle(circle string, limit int) ([]*Node, error) {
             &node.Circle,
             &node.Vendor,
             &node.NodeType,
+            &node.CustomCommands,
         )
         if err != nil {
             return nil, err
@@ -159,3 +183,79 @@ func (m *Manager) GetNodesByCircle(circle string, limit int) ([]*Node, error) {
 
     return nodes, nil
 }
+
+// NodeFilter selects nodes in ListNodes. Empty fields match every node.
+type NodeFilter struct {
+    Circle   string
+    Site     string
+    Vendor   string
+    NodeType string
+    Status   string
+}
+
+// ListNodes returns one page of the enabled nodes matching f, ordered by
+// neId, and the total number of matching nodes
+func (m *Manager) ListNodes(f NodeFilter, limit, offset int) ([]*Node, int, error) {
+    where := `
+        FROM hc_nodes n
+        LEFT JOIN hc_node_status s ON n.neId = s.neId
+        WHERE n.Login_status = 'Yes'
+          AND (? = '' OR n.Circle = ?)
+          AND (? = '' OR n.Site = ?)
+          AND (? = '' OR n.vendor = ?)
+          AND (? = '' OR n.node_type = ?)
+          AND (? = '' OR s.current_status = ?)
+    `
+    args := []interface{}{
+        f.Circle, f.Circle,
+        f.Site, f.Site,
+        f.Vendor, f.Vendor,
+        f.NodeType, f.NodeType,
+        f.Status, f.Status,
+    }
+
+    var total int
+    if err := m.db.QueryRow(`SELECT COUNT(*) `+where, args...).Scan(&total); err != nil {
+        return nil, 0, err
+    }
+
+    rows, err := m.db.Query(`
+        SELECT
+            n.neId,
+            n.IPAddress,
+            n.Hostname,
+            COALESCE(n.Site, ''),
+            COALESCE(n.Circle, ''),
+            COALESCE(n.vendor, 'unknown') as vendor,
+            COALESCE(n.node_type, 'router') as node_type,
+            n.custom_commands
+        `+where+`
+        ORDER BY n.neId ASC
+        LIMIT ? OFFSET ?
+    `, append(args, limit, offset)...)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer rows.Close()
+
+    nodes := []*Node{}
+    for rows.Next() {
+        node := &Node{}
+        err := rows.Scan(
+            &node.NeID,
+            &node.IPAddress,
+            &node.Hostname,
+            &node.Site,
+            &node.Circle,
+            &node.Vendor,
+            &node.NodeType,
+            &node.CustomCommands,
+        )
+        if err != nil {
+            return nil, 0, err
+        }
+        nodes = append(nodes, node)
+    }
+
+    return nodes, total, rows.Err()
+}
@@ -0,0 +1,119 @@
+package logging
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// Config holds logging settings
+type Config struct {
+    Level      string
+    File       string
+    MaxSizeMB  int
+    MaxBackups int
+}
+
+var debug bool
+
+// Setup sends the standard logger to stdout and, when File is set, to a
+// size-rotated log file. The returned closer closes the file.
+func Setup(cfg Config) (io.Closer, error) {
+    debug = strings.EqualFold(cfg.Level, "DEBUG")
+
+    if cfg.File == "" {
+        log.SetOutput(os.Stdout)
+        return io.NopCloser(nil), nil
+    }
+
+    if err := os.MkdirAll(filepath.Dir(cfg.File), 0755); err != nil {
+        return nil, fmt.Errorf("failed to create log directory: %w", err)
+    }
+
+    w := &rotatingFile{
+        path:       cfg.File,
+        maxSize:    int64(cfg.MaxSizeMB) * 1024 * 1024,
+        maxBackups: cfg.MaxBackups,
+    }
+    if err := w.open(); err != nil {
+        return nil, err
+    }
+
+    log.SetOutput(io.MultiWriter(os.Stdout, w))
+    return w, nil
+}
+
+// Debugf logs only when the level is DEBUG
+func Debugf(format string, v ...interface{}) {
+    if debug {
+        log.Printf("DEBUG "+format, v...)
+    }
+}
+
+// rotatingFile is a log file that is renamed to path.1, path.2, ... once
+// it grows past maxSize
+type rotatingFile struct {
+    mu         sync.Mutex
+    path       string
+    maxSize    int64
+    maxBackups int
+    file       *os.File
+    size       int64
+}
+
+func (w *rotatingFile) open() error {
+    f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open log file: %w", err)
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+
+    w.file = f
+    w.size = info.Size()
+    return nil
+}
+
+func (w *rotatingFile) Write(p []byte) (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+
+    if w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize {
+        if err := w.rotate(); err != nil {
+            return 0, err
+        }
+    }
+
+    n, err := w.file.Write(p)
+    w.size += int64(n)
+    return n, err
+}
+
+func (w *rotatingFile) rotate() error {
+    w.file.Close()
+
+    if w.maxBackups > 0 {
+        os.Remove(fmt.Sprintf("%s.%d", w.path, w.maxBackups))
+        for i := w.maxBackups - 1; i > 0; i-- {
+            os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+        }
+        os.Rename(w.path, w.path+".1")
+    } else {
+        os.Remove(w.path)
+    }
+
+    return w.open()
+}
+
+func (w *rotatingFile) Close() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.file.Close()
+}
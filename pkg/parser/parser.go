@@ -0,0 +1,118 @@
+package parser
+
+import (
+    "fmt"
+    "sort"
+)
+
+// Alarm is an active alarm reported by a node
+type Alarm struct {
+    Severity    string `json:"severity"`
+    Description string `json:"description"`
+}
+
+// CommandStatus records how a command and its parser fared
+type CommandStatus struct {
+    Name       string `json:"name"`
+    Command    string `json:"command"`
+    Parser     string `json:"parser,omitempty"`
+    Error      string `json:"error,omitempty"`
+    ParseError string `json:"parse_error,omitempty"`
+}
+
+// Metrics is the structured result of a health check, stored in
+// hc_history.metrics. Fields are nil when no command reported them.
+type Metrics struct {
+    CPUPercent      *float64 `json:"cpu_percent,omitempty"`
+    MemoryPercent   *float64 `json:"memory_percent,omitempty"`
+    InterfaceErrors *int64   `json:"interface_errors,omitempty"`
+    UptimeSeconds   *int64   `json:"uptime_seconds,omitempty"`
+
+    // Alarms is null when no alarm command was parsed and empty when the
+    // node reported none
+    Alarms []Alarm `json:"alarms"`
+
+    Commands []CommandStatus `json:"commands"`
+}
+
+// Func parses the output of one command into m
+type Func func(output string, m *Metrics) error
+
+var parsers = map[string]Func{}
+
+// Register adds a parser under name. It panics on duplicates, so it is
+// meant to be called from init.
+func Register(name string, fn Func) {
+    if _, ok := parsers[name]; ok {
+        panic(fmt.Sprintf("parser %q registered twice", name))
+    }
+    parsers[name] = fn
+}
+
+// Get returns the parser registered under name
+func Get(name string) (Func, bool) {
+    fn, ok := parsers[name]
+    return fn, ok
+}
+
+// Names returns the registered parser names
+func Names() []string {
+    names := make([]string, 0, len(parsers))
+    for name := range parsers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Output is the raw output of one command
+type Output struct {
+    Name    string
+    Command string
+    Parser  string
+    Output  string
+    Err     error
+}
+
+// Parse runs each command's parser over its output. Command and parse
+// failures are recorded in Metrics.Commands rather than returned.
+func Parse(outputs []Output) *Metrics {
+    m := &Metrics{
+        Commands: make([]CommandStatus, 0, len(outputs)),
+    }
+
+    for _, o := range outputs {
+        st := CommandStatus{
+            Name:    o.Name,
+            Command: o.Command,
+            Parser:  o.Parser,
+        }
+
+        switch {
+        case o.Err != nil:
+            st.Error = o.Err.Error()
+        case o.Parser == "":
+        default:
+            if fn, ok := Get(o.Parser); !ok {
+                st.ParseError = fmt.Sprintf("unknown parser %q", o.Parser)
+            } else if err := fn(o.Output, m); err != nil {
+                st.ParseError = err.Error()
+            }
+        }
+
+        m.Commands = append(m.Commands, st)
+    }
+
+    return m
+}
+
+// Failed returns the number of commands that failed to run or parse
+func (m *Metrics) Failed() int {
+    n := 0
+    for _, c := range m.Commands {
+        if c.Error != "" || c.ParseError != "" {
+            n++
+        }
+    }
+    return n
+}
@@ -0,0 +1,500 @@
+package parser
+
+import (
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+func init() {
+    // Cisco
+    Register("cisco_version", parseUptimePhrase)
+    Register("cisco_cpu", parseCiscoCPU)
+    Register("cisco_memory", parseCiscoMemory)
+    Register("cisco_interfaces", parseCiscoInterfaces)
+    Register("cisco_alarms", parseSeverityAlarms)
+
+    // Juniper
+    Register("juniper_routing_engine", parseJuniperRoutingEngine)
+    Register("juniper_interfaces", parseJuniperInterfaces)
+    Register("juniper_uptime", parseBSDUptime)
+    Register("juniper_alarms", parseJuniperAlarms)
+
+    // Huawei
+    Register("huawei_version", parseUptimePhrase)
+    Register("huawei_cpu", parseHuaweiCPU)
+    Register("huawei_memory", parseHuaweiMemory)
+    Register("huawei_interfaces", parseHuaweiInterfaces)
+    Register("huawei_alarms", parseSeverityAlarms)
+
+    // Nokia
+    Register("nokia_cpu", parseNokiaCPU)
+    Register("nokia_memory", parseNokiaMemory)
+    Register("nokia_uptime", parseNokiaUptime)
+    Register("nokia_alarms", parseSeverityAlarms)
+
+    // Linux
+    Register("linux_cpu", parseLinuxCPU)
+    Register("linux_memory", parseLinuxMemory)
+    Register("linux_interfaces", parseLinuxInterfaces)
+    Register("linux_uptime", parseLinuxUptime)
+}
+
+var (
+    uptimePhraseRe = regexp.MustCompile(`(?i)uptime is ([^\n]+)`)
+    uptimeUnitRe   = regexp.MustCompile(`(?i)(\d+)\s*(year|week|day|hour|minute|second)s?`)
+
+    ciscoCPURe    = regexp.MustCompile(`(?i)one minute:\s*(\d+)%`)
+    ciscoMemoryRe = regexp.MustCompile(`(?i)Processor Pool Total:\s*(\d+)\s+Used:\s*(\d+)`)
+    ciscoErrorsRe = regexp.MustCompile(`(?i)(\d+) (?:input|output) errors`)
+
+    juniperIdleRe   = regexp.MustCompile(`(?i)Idle\s+(\d+) percent`)
+    juniperMemRe    = regexp.MustCompile(`(?i)Memory utilization\s+(\d+) percent`)
+    juniperErrorsRe = regexp.MustCompile(`(?i)\bErrors:\s*(\d+)`)
+    juniperAlarmRe  = regexp.MustCompile(`(?i)^\S+ \S+ \S+\s+(Major|Minor)\s+(.+)$`)
+    bsdUptimeRe     = regexp.MustCompile(`(?i)\bup\s+(?:(\d+)\s+days?,\s*)?(?:(\d+):(\d+)|(\d+)\s+mins?)`)
+
+    huaweiCPURe    = regexp.MustCompile(`(?i)CPU (?:Usage|utilization for one minute)\s*:?\s*(\d+(?:\.\d+)?)%`)
+    huaweiMemoryRe = regexp.MustCompile(`(?i)Memory Using Percentage(?: Is)?\s*:\s*(\d+(?:\.\d+)?)%`)
+
+    nokiaIdleRe   = regexp.MustCompile(`(?i)^\s*Idle\s+.*?(\d+(?:\.\d+)?)%\s*$`)
+    nokiaBusyRe   = regexp.MustCompile(`(?i)Busiest Core Utilization\s+.*?(\d+(?:\.\d+)?)%`)
+    nokiaTotalRe  = regexp.MustCompile(`(?i)Current Total Size\s*:\s*([\d,]+)`)
+    nokiaInUseRe  = regexp.MustCompile(`(?i)Total In Use\s*:\s*([\d,]+)`)
+    nokiaUptimeRe = regexp.MustCompile(`(?i)System Up Time\s*:\s*(?:(\d+) days?,\s*)?(\d+):(\d+):(\d+)`)
+
+    linuxCPURe = regexp.MustCompile(`(?i)%?Cpu\(s\):.*?(\d+(?:\.\d+)?)\s*id`)
+
+    severityHeaderRe  = regexp.MustCompile(`(?i)(?:^|\s)(Severity)(?:\s|$)`)
+    severitySummaryRe = regexp.MustCompile(`(?i)\b(critical|major|minor)\s*:\s*(\d+)`)
+)
+
+// parseUptimePhrase handles "uptime is 2 weeks, 3 days, 4 hours, 5 minutes"
+func parseUptimePhrase(output string, m *Metrics) error {
+    match := uptimePhraseRe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("uptime not found")
+    }
+
+    var seconds int64
+    for _, u := range uptimeUnitRe.FindAllStringSubmatch(match[1], -1) {
+        n, _ := strconv.ParseInt(u[1], 10, 64)
+        switch strings.ToLower(u[2]) {
+        case "year":
+            seconds += n * 365 * 86400
+        case "week":
+            seconds += n * 7 * 86400
+        case "day":
+            seconds += n * 86400
+        case "hour":
+            seconds += n * 3600
+        case "minute":
+            seconds += n * 60
+        case "second":
+            seconds += n
+        }
+    }
+
+    m.UptimeSeconds = &seconds
+    return nil
+}
+
+// parseCiscoCPU handles "CPU utilization for five seconds: 5%/0%; one minute: 3%; ..."
+func parseCiscoCPU(output string, m *Metrics) error {
+    match := ciscoCPURe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("CPU utilization not found")
+    }
+    return setFloat(&m.CPUPercent, match[1])
+}
+
+// parseCiscoMemory handles "Processor Pool Total: 1650309472 Used: 321523088 Free: ..."
+func parseCiscoMemory(output string, m *Metrics) error {
+    match := ciscoMemoryRe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("processor pool not found")
+    }
+    return setRatio(&m.MemoryPercent, match[2], match[1])
+}
+
+// parseCiscoInterfaces sums "N input errors" and "N output errors"
+func parseCiscoInterfaces(output string, m *Metrics) error {
+    return sumMatches(&m.InterfaceErrors, ciscoErrorsRe, output)
+}
+
+// parseJuniperRoutingEngine reads CPU idle and memory utilization
+func parseJuniperRoutingEngine(output string, m *Metrics) error {
+    idle := juniperIdleRe.FindStringSubmatch(output)
+    if idle == nil {
+        return fmt.Errorf("CPU idle not found")
+    }
+    n, err := strconv.ParseFloat(idle[1], 64)
+    if err != nil {
+        return err
+    }
+    cpu := 100 - n
+    m.CPUPercent = &cpu
+
+    if mem := juniperMemRe.FindStringSubmatch(output); mem != nil {
+        return setFloat(&m.MemoryPercent, mem[1])
+    }
+    return nil
+}
+
+// parseJuniperInterfaces sums every "Errors: N" counter
+func parseJuniperInterfaces(output string, m *Metrics) error {
+    return sumMatches(&m.InterfaceErrors, juniperErrorsRe, output)
+}
+
+// parseJuniperAlarms handles "show chassis alarms"
+func parseJuniperAlarms(output string, m *Metrics) error {
+    var alarms []Alarm
+    if !strings.Contains(strings.ToLower(output), "no alarms currently active") {
+        for _, line := range strings.Split(output, "\n") {
+            if match := juniperAlarmRe.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+                alarms = append(alarms, Alarm{
+                    Severity:    strings.ToLower(match[1]),
+                    Description: strings.TrimSpace(match[2]),
+                })
+            }
+        }
+    }
+
+    addAlarms(m, alarms)
+    return nil
+}
+
+// parseBSDUptime handles " 2:06PM  up 148 days,  5:54, 1 user, ..."
+func parseBSDUptime(output string, m *Metrics) error {
+    match := bsdUptimeRe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("uptime not found")
+    }
+
+    var seconds int64
+    days, _ := strconv.ParseInt(match[1], 10, 64)
+    hours, _ := strconv.ParseInt(match[2], 10, 64)
+    minutes, _ := strconv.ParseInt(match[3], 10, 64)
+    if match[4] != "" {
+        minutes, _ = strconv.ParseInt(match[4], 10, 64)
+    }
+    seconds = days*86400 + hours*3600 + minutes*60
+
+    m.UptimeSeconds = &seconds
+    return nil
+}
+
+// parseHuaweiCPU handles "CPU Usage : 12% Max: 50%"
+func parseHuaweiCPU(output string, m *Metrics) error {
+    match := huaweiCPURe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("CPU usage not found")
+    }
+    return setFloat(&m.CPUPercent, match[1])
+}
+
+// parseHuaweiMemory handles "Memory Using Percentage Is: 45%"
+func parseHuaweiMemory(output string, m *Metrics) error {
+    match := huaweiMemoryRe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("memory usage not found")
+    }
+    return setFloat(&m.MemoryPercent, match[1])
+}
+
+// parseHuaweiInterfaces sums the inErrors and outErrors columns of
+// "display interface brief"
+func parseHuaweiInterfaces(output string, m *Metrics) error {
+    lines := strings.Split(output, "\n")
+
+    inCol, outCol := -1, -1
+    var total int64
+    for _, line := range lines {
+        fields := strings.Fields(line)
+        if inCol < 0 {
+            for i, f := range fields {
+                switch strings.ToLower(f) {
+                case "inerrors":
+                    inCol = i
+                case "outerrors":
+                    outCol = i
+                }
+            }
+            continue
+        }
+
+        if len(fields) <= inCol || len(fields) <= outCol {
+            continue
+        }
+        in, err1 := strconv.ParseInt(fields[inCol], 10, 64)
+        out, err2 := strconv.ParseInt(fields[outCol], 10, 64)
+        if err1 != nil || err2 != nil {
+            continue
+        }
+        total += in + out
+    }
+
+    if inCol < 0 || outCol < 0 {
+        return fmt.Errorf("error columns not found")
+    }
+
+    m.InterfaceErrors = addInt(m.InterfaceErrors, total)
+    return nil
+}
+
+// parseNokiaCPU reads the idle line, falling back to the busiest core
+func parseNokiaCPU(output string, m *Metrics) error {
+    for _, line := range strings.Split(output, "\n") {
+        if match := nokiaIdleRe.FindStringSubmatch(line); match != nil {
+            n, err := strconv.ParseFloat(match[1], 64)
+            if err != nil {
+                return err
+            }
+            cpu := 100 - n
+            m.CPUPercent = &cpu
+            return nil
+        }
+    }
+
+    if match := nokiaBusyRe.FindStringSubmatch(output); match != nil {
+        return setFloat(&m.CPUPercent, match[1])
+    }
+    return fmt.Errorf("CPU utilization not found")
+}
+
+// parseNokiaMemory handles "Current Total Size : N bytes" and "Total In Use : N bytes"
+func parseNokiaMemory(output string, m *Metrics) error {
+    total := nokiaTotalRe.FindStringSubmatch(output)
+    inUse := nokiaInUseRe.FindStringSubmatch(output)
+    if total == nil || inUse == nil {
+        return fmt.Errorf("memory pool totals not found")
+    }
+    return setRatio(&m.MemoryPercent,
+        strings.ReplaceAll(inUse[1], ",", ""),
+        strings.ReplaceAll(total[1], ",", ""))
+}
+
+// parseNokiaUptime handles "System Up Time : 62 days, 03:14:39.13 (hr:min:sec)"
+func parseNokiaUptime(output string, m *Metrics) error {
+    match := nokiaUptimeRe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("uptime not found")
+    }
+
+    days, _ := strconv.ParseInt(match[1], 10, 64)
+    hours, _ := strconv.ParseInt(match[2], 10, 64)
+    minutes, _ := strconv.ParseInt(match[3], 10, 64)
+    secs, _ := strconv.ParseInt(match[4], 10, 64)
+    seconds := days*86400 + hours*3600 + minutes*60 + secs
+
+    m.UptimeSeconds = &seconds
+    return nil
+}
+
+// parseLinuxCPU handles top's "%Cpu(s):  2.0 us,  1.0 sy, ... 96.5 id, ..."
+func parseLinuxCPU(output string, m *Metrics) error {
+    match := linuxCPURe.FindStringSubmatch(output)
+    if match == nil {
+        return fmt.Errorf("CPU summary not found")
+    }
+    idle, err := strconv.ParseFloat(match[1], 64)
+    if err != nil {
+        return err
+    }
+    cpu := 100 - idle
+    m.CPUPercent = &cpu
+    return nil
+}
+
+// parseLinuxMemory handles free's "Mem: total used free ..." line
+func parseLinuxMemory(output string, m *Metrics) error {
+    for _, line := range strings.Split(output, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) >= 3 && fields[0] == "Mem:" {
+            return setRatio(&m.MemoryPercent, fields[2], fields[1])
+        }
+    }
+    return fmt.Errorf("Mem line not found")
+}
+
+// parseLinuxInterfaces sums the receive and transmit errs columns of
+// /proc/net/dev
+func parseLinuxInterfaces(output string, m *Metrics) error {
+    var total int64
+    found := false
+    for _, line := range strings.Split(output, "\n") {
+        name, counters, ok := strings.Cut(line, ":")
+        if !ok || strings.Contains(name, "|") {
+            continue
+        }
+        fields := strings.Fields(counters)
+        if len(fields) < 11 {
+            continue
+        }
+        rx, err1 := strconv.ParseInt(fields[2], 10, 64)
+        tx, err2 := strconv.ParseInt(fields[10], 10, 64)
+        if err1 != nil || err2 != nil {
+            continue
+        }
+        total += rx + tx
+        found = true
+    }
+
+    if !found {
+        return fmt.Errorf("no interface counters found")
+    }
+
+    m.InterfaceErrors = addInt(m.InterfaceErrors, total)
+    return nil
+}
+
+// parseLinuxUptime handles /proc/uptime: "350735.47 234388.90"
+func parseLinuxUptime(output string, m *Metrics) error {
+    fields := strings.Fields(output)
+    if len(fields) == 0 {
+        return fmt.Errorf("empty uptime")
+    }
+    f, err := strconv.ParseFloat(fields[0], 64)
+    if err != nil {
+        return fmt.Errorf("invalid uptime %q", fields[0])
+    }
+
+    seconds := int64(f)
+    m.UptimeSeconds = &seconds
+    return nil
+}
+
+// parseSeverityAlarms reads alarm tables with a Severity column, as
+// printed by Cisco "show facility-alarm status", Huawei "display alarm
+// active" and Nokia "show system alarms". Only rows below the header
+// count, so summary lines such as "System Totals  Critical: 0" are
+// skipped. Rows below minor severity are ignored.
+func parseSeverityAlarms(output string, m *Metrics) error {
+    lines := strings.Split(output, "\n")
+
+    col := -1
+    var alarms []Alarm
+    for i, line := range lines {
+        if col < 0 {
+            if loc := severityHeaderRe.FindStringSubmatchIndex(line); loc != nil {
+                col = loc[2]
+            }
+            continue
+        }
+
+        severity, description, ok := severityField(line, col)
+        if !ok {
+            continue
+        }
+        switch severity {
+        case "critical", "major", "minor":
+        default:
+            continue
+        }
+
+        // Nokia prints the alarm text on the line below the row
+        if description == "" && i+1 < len(lines) {
+            description = strings.TrimSpace(lines[i+1])
+        }
+        alarms = append(alarms, Alarm{Severity: severity, Description: description})
+    }
+
+    if col < 0 {
+        // Without a table the node reports no alarms, which the summary
+        // line must agree with
+        for _, match := range severitySummaryRe.FindAllStringSubmatch(output, -1) {
+            if match[2] != "0" {
+                return fmt.Errorf("%s alarms reported but no alarm table found", match[1])
+            }
+        }
+        lower := strings.ToLower(output)
+        if severitySummaryRe.MatchString(output) || strings.Contains(lower, "no alarm") {
+            addAlarms(m, nil)
+            return nil
+        }
+        return fmt.Errorf("alarm table not found")
+    }
+
+    addAlarms(m, alarms)
+    return nil
+}
+
+// severityField returns the severity of a table row: the first known
+// severity word starting at or after the header's Severity column (with a
+// little slack for misaligned rows), and the text after it
+func severityField(line string, col int) (string, string, bool) {
+    pos := 0
+    for _, field := range strings.Fields(line) {
+        start := pos + strings.Index(line[pos:], field)
+        pos = start + len(field)
+        if start < col-2 {
+            continue
+        }
+
+        switch sev := strings.ToLower(field); sev {
+        case "critical", "major", "minor", "warning", "info", "informational", "indeterminate", "cleared":
+            return sev, strings.TrimSpace(line[pos:]), true
+        }
+    }
+    return "", "", false
+}
+
+func setFloat(dst **float64, s string) error {
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+        return fmt.Errorf("invalid number %q", s)
+    }
+    *dst = &f
+    return nil
+}
+
+// setRatio stores used/total as a percentage
+func setRatio(dst **float64, used, total string) error {
+    u, err := strconv.ParseFloat(used, 64)
+    if err != nil {
+        return fmt.Errorf("invalid number %q", used)
+    }
+    t, err := strconv.ParseFloat(total, 64)
+    if err != nil || t == 0 {
+        return fmt.Errorf("invalid total %q", total)
+    }
+    pct := u * 100 / t
+    *dst = &pct
+    return nil
+}
+
+// sumMatches adds the first group of every match to dst
+func sumMatches(dst **int64, re *regexp.Regexp, output string) error {
+    matches := re.FindAllStringSubmatch(output, -1)
+    if matches == nil {
+        return fmt.Errorf("no error counters found")
+    }
+
+    var total int64
+    for _, match := range matches {
+        n, err := strconv.ParseInt(match[1], 10, 64)
+        if err != nil {
+            return fmt.Errorf("invalid counter %q", match[1])
+        }
+        total += n
+    }
+
+    *dst = addInt(*dst, total)
+    return nil
+}
+
+// addAlarms appends alarms, leaving Alarms non-nil to show they were collected
+func addAlarms(m *Metrics, alarms []Alarm) {
+    if m.Alarms == nil {
+        m.Alarms = []Alarm{}
+    }
+    m.Alarms = append(m.Alarms, alarms...)
+}
+
+func addInt(p *int64, n int64) *int64 {
+    if p != nil {
+        n += *p
+    }
+    return &n
+}
@@ -0,0 +1,309 @@
+package parser
+
+import (
+    "math"
+    "reflect"
+    "testing"
+)
+
+func fp(f float64) *float64 { return &f }
+func ip(n int64) *int64     { return &n }
+
+const ciscoAlarms = `System Totals  Critical: 1  Major: 1  Minor: 0
+
+Source                 Time                   Severity      Description [Index]
+------                 ------                 --------      -------------------
+GigabitEthernet0/0/1   Jan 10 2024 08:15:32   CRITICAL      Physical Port Link Down [1]
+Power Supply Bay 1     Jan 10 2024 08:16:01   MAJOR         Power Supply Failure [0]
+subslot 0/1            Jan 09 2024 22:01:44   INFO          Transceiver Missing [0]
+`
+
+const huaweiAlarms = `A/B/C/D/E/F/G/H/I/J
+A=Sequence, B=RootKindFlag(Independent|RootCause|nonRootCause)
+C=AlarmId, D=Severity, E=Date Time, F=Description
+------------------------------------------------------------------------------
+Sequence   AlarmId    Severity Date Time                 Description
+------------------------------------------------------------------------------
+1020       0x8520003  Major    2024-01-10 08:15:32+08:00 The interface status changes. (ifName=GE0/0/1)
+1021       0x813F0002 Minor    2024-01-11 10:02:11+08:00 The optical module is abnormal. (ifName=GE0/0/2)
+1022       0x81300001 Warning  2024-01-11 10:05:00+08:00 The CPU usage exceeded the threshold.
+------------------------------------------------------------------------------
+`
+
+const nokiaAlarms = `===============================================================================
+Alarms [Critical:0 Major:1 Minor:0 Warning:1 Indeterminate:0]
+===============================================================================
+Index      Date/Time                 OID                        Severity
+Alarm Text
+-------------------------------------------------------------------------------
+2          2024/01/10 08:15:32.00 UT tmnxEqPortSFPRemoved       MAJOR
+           1/1/3: SFP removed
+3          2024/01/10 08:20:11.00 UT tmnxEqPortError            WARNING
+           1/1/4: Port error
+===============================================================================
+`
+
+const nokiaCPU = `===============================================================================
+CPU Utilization (Sample period: 1 second)
+===============================================================================
+Name                               CPU Time     CPU Usage    Capacity
+                                   (uSec)                    Usage
+-------------------------------------------------------------------------------
+BFD                                      0        ~0.00%       ~0.00%
+BGP                                  1,312         0.13%        0.13%
+Idle                               924,010        92.40%
+-------------------------------------------------------------------------------
+Total                            1,000,000       100.00%
+Busiest Core Utilization            81,231         8.12%
+===============================================================================
+`
+
+const linuxNetDev = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 2776770   11307    0    0    0     0          0         0  2776770   11307    0    0    0     0       0          0
+  eth0: 1215645    2751    3    0    0     0          0         0  1782404    4324    2    0    0   427       0          0
+`
+
+func TestParsers(t *testing.T) {
+    tests := []struct {
+        name    string
+        parser  string
+        output  string
+        want    Metrics
+        wantErr bool
+    }{
+        // Cisco
+        {
+            name:   "cisco version",
+            parser: "cisco_version",
+            output: "Cisco IOS XE Software, Version 17.03.04a\nrtr-01 uptime is 2 weeks, 3 days, 4 hours, 5 minutes\nUptime for this control processor is 2 weeks, 3 days, 4 hours, 7 minutes\n",
+            want:   Metrics{UptimeSeconds: ip(1483500)},
+        },
+        {
+            name:   "cisco cpu",
+            parser: "cisco_cpu",
+            output: "CPU utilization for five seconds: 5%/0%; one minute: 3%; five minutes: 2%\n PID Runtime(ms)     Invoked      uSecs   5Sec   1Min   5Min TTY Process\n",
+            want:   Metrics{CPUPercent: fp(3)},
+        },
+        {
+            name:   "cisco memory",
+            parser: "cisco_memory",
+            output: "Processor Pool Total: 1650309472 Used:  412577368 Free: 1237732104\n lsmpi_io Pool Total:    6295128 Used:    6294296 Free:        832\n",
+            want:   Metrics{MemoryPercent: fp(25)},
+        },
+        {
+            name:   "cisco interfaces",
+            parser: "cisco_interfaces",
+            output: "GigabitEthernet0/0/0 is up, line protocol is up\n     2 input errors, 0 CRC, 0 frame, 0 overrun, 0 ignored\n     3 output errors, 0 collisions, 1 interface resets\nGigabitEthernet0/0/1 is up, line protocol is up\n     0 input errors, 0 CRC, 0 frame, 0 overrun, 0 ignored\n     0 output errors, 0 collisions, 0 interface resets\n",
+            want:   Metrics{InterfaceErrors: ip(5)},
+        },
+        {
+            name:   "cisco alarms",
+            parser: "cisco_alarms",
+            output: ciscoAlarms,
+            want: Metrics{Alarms: []Alarm{
+                {Severity: "critical", Description: "Physical Port Link Down [1]"},
+                {Severity: "major", Description: "Power Supply Failure [0]"},
+            }},
+        },
+        {
+            name:   "cisco alarms none",
+            parser: "cisco_alarms",
+            output: "System Totals  Critical: 0  Major: 0  Minor: 0\n\nSource                 Time                   Severity      Description [Index]\n------                 ------                 --------      -------------------\n",
+            want:   Metrics{Alarms: []Alarm{}},
+        },
+        {
+            name:   "cisco alarms summary only",
+            parser: "cisco_alarms",
+            output: "System Totals  Critical: 0  Major: 0  Minor: 0\n",
+            want:   Metrics{Alarms: []Alarm{}},
+        },
+        {
+            name:    "cisco alarms summary without table",
+            parser:  "cisco_alarms",
+            output:  "System Totals  Critical: 2  Major: 0  Minor: 0\n",
+            wantErr: true,
+        },
+
+        // Juniper
+        {
+            name:   "juniper routing engine",
+            parser: "juniper_routing_engine",
+            output: "Routing Engine status:\n  Slot 0:\n    Current state                  Master\n    Temperature                 38 degrees C / 100 degrees F\n    Memory utilization          23 percent\n    CPU utilization:\n      User                       5 percent\n      Kernel                     3 percent\n      Interrupt                  0 percent\n      Idle                      92 percent\n",
+            want:   Metrics{CPUPercent: fp(8), MemoryPercent: fp(23)},
+        },
+        {
+            name:   "juniper interfaces",
+            parser: "juniper_interfaces",
+            output: "  Input errors:\n    Errors: 2, Drops: 0, Framing errors: 1, Runts: 0, Policed discards: 0\n  Output errors:\n    Carrier transitions: 1, Errors: 3, Drops: 0, Collisions: 0\n",
+            want:   Metrics{InterfaceErrors: ip(6)},
+        },
+        {
+            name:   "juniper uptime",
+            parser: "juniper_uptime",
+            output: "Current time: 2024-01-10 08:15:32 UTC\nSystem booted: 2023-08-15 02:21:07 UTC (21w1d 05:54 ago)\nLast configured: 2024-01-02 11:00:00 UTC (1w0d 21:15 ago) by admin\n 8:15AM  up 148 days,  5:54, 1 user, load averages: 0.08, 0.05, 0.01\n",
+            want:   Metrics{UptimeSeconds: ip(12808440)},
+        },
+        {
+            name:   "juniper alarms",
+            parser: "juniper_alarms",
+            output: "2 alarms currently active\nAlarm time               Class  Description\n2024-01-10 08:15:32 UTC  Major  FPC 0 PIC 1 Failure\n2024-01-10 08:16:00 UTC  Minor  Loss of communication with Backup RE\n",
+            want: Metrics{Alarms: []Alarm{
+                {Severity: "major", Description: "FPC 0 PIC 1 Failure"},
+                {Severity: "minor", Description: "Loss of communication with Backup RE"},
+            }},
+        },
+        {
+            name:   "juniper alarms none",
+            parser: "juniper_alarms",
+            output: "No alarms currently active\n",
+            want:   Metrics{Alarms: []Alarm{}},
+        },
+
+        // Huawei
+        {
+            name:   "huawei version",
+            parser: "huawei_version",
+            output: "Huawei Versatile Routing Platform Software\nVRP (R) software, Version 8.180 (NE40E V800R011C00SPC200)\nHUAWEI NE40E-X8A uptime is 62 days, 3 hours, 14 minutes\n",
+            want:   Metrics{UptimeSeconds: ip(5368440)},
+        },
+        {
+            name:   "huawei cpu",
+            parser: "huawei_cpu",
+            output: "CPU Usage Stat. Cycle: 60 (Second)\nCPU Usage            : 12% Max: 50%\nCPU Usage Stat. Time : 2024-01-10  08:15:32\n",
+            want:   Metrics{CPUPercent: fp(12)},
+        },
+        {
+            name:   "huawei memory",
+            parser: "huawei_memory",
+            output: "Memory utilization statistics at 2024-01-10 08:15:32 783 ms\nSystem Total Memory Is: 4194304 Kbytes\nTotal Memory Used Is: 1887436 Kbytes\nMemory Using Percentage Is: 45%\n",
+            want:   Metrics{MemoryPercent: fp(45)},
+        },
+        {
+            name:   "huawei interfaces",
+            parser: "huawei_interfaces",
+            output: "PHY: Physical\n*down: administratively down\nInUti/OutUti: input utility/output utility\nInterface                   PHY   Protocol  InUti OutUti   inErrors  outErrors\nGigabitEthernet0/0/0        up    up        0.01%  0.01%          2          1\nGigabitEthernet0/0/1        down  down         0%     0%          0          4\n",
+            want:   Metrics{InterfaceErrors: ip(7)},
+        },
+        {
+            name:   "huawei alarms",
+            parser: "huawei_alarms",
+            output: huaweiAlarms,
+            want: Metrics{Alarms: []Alarm{
+                {Severity: "major", Description: "2024-01-10 08:15:32+08:00 The interface status changes. (ifName=GE0/0/1)"},
+                {Severity: "minor", Description: "2024-01-11 10:02:11+08:00 The optical module is abnormal. (ifName=GE0/0/2)"},
+            }},
+        },
+
+        // Nokia
+        {
+            name:   "nokia cpu",
+            parser: "nokia_cpu",
+            output: nokiaCPU,
+            want:   Metrics{CPUPercent: fp(7.6)},
+        },
+        {
+            name:   "nokia memory",
+            parser: "nokia_memory",
+            output: "===============================================================================\nOverall Memory Usage\n===============================================================================\nCurrent Total Size :    3,200,000,000 bytes\nTotal In Use       :      800,000,000 bytes\nAvailable Memory   :    2,400,000,000 bytes\n",
+            want:   Metrics{MemoryPercent: fp(25)},
+        },
+        {
+            name:   "nokia uptime",
+            parser: "nokia_uptime",
+            output: "System Up Time         : 62 days, 03:14:39.13 (hr:min:sec)\n",
+            want:   Metrics{UptimeSeconds: ip(5368479)},
+        },
+        {
+            name:   "nokia alarms",
+            parser: "nokia_alarms",
+            output: nokiaAlarms,
+            want: Metrics{Alarms: []Alarm{
+                {Severity: "major", Description: "1/1/3: SFP removed"},
+            }},
+        },
+
+        // Linux
+        {
+            name:   "linux cpu",
+            parser: "linux_cpu",
+            output: "top - 08:15:32 up 4 days,  1:02,  1 user,  load average: 0.08, 0.03, 0.01\nTasks: 112 total,   1 running, 111 sleeping,   0 stopped,   0 zombie\n%Cpu(s):  2.0 us,  1.0 sy,  0.0 ni, 96.5 id,  0.3 wa,  0.0 hi,  0.2 si,  0.0 st\n",
+            want:   Metrics{CPUPercent: fp(3.5)},
+        },
+        {
+            name:   "linux memory",
+            parser: "linux_memory",
+            output: "              total        used        free      shared  buff/cache   available\nMem:        8000000     2000000     4000000       10000     2000000     5800000\nSwap:       2000000           0     2000000\n",
+            want:   Metrics{MemoryPercent: fp(25)},
+        },
+        {
+            name:   "linux interfaces",
+            parser: "linux_interfaces",
+            output: linuxNetDev,
+            want:   Metrics{InterfaceErrors: ip(5)},
+        },
+        {
+            name:   "linux uptime",
+            parser: "linux_uptime",
+            output: "350735.47 234388.90\n",
+            want:   Metrics{UptimeSeconds: ip(350735)},
+        },
+        {
+            name:    "linux uptime empty",
+            parser:  "linux_uptime",
+            output:  "",
+            wantErr: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fn, ok := Get(tt.parser)
+            if !ok {
+                t.Fatalf("parser %q not registered", tt.parser)
+            }
+
+            got := &Metrics{}
+            err := fn(tt.output, got)
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatalf("expected error, got %+v", got)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("unexpected error: %v", err)
+            }
+
+            checkFloat(t, "cpu", got.CPUPercent, tt.want.CPUPercent)
+            checkFloat(t, "memory", got.MemoryPercent, tt.want.MemoryPercent)
+            checkInt(t, "interface errors", got.InterfaceErrors, tt.want.InterfaceErrors)
+            checkInt(t, "uptime", got.UptimeSeconds, tt.want.UptimeSeconds)
+            if !reflect.DeepEqual(got.Alarms, tt.want.Alarms) {
+                t.Errorf("alarms = %#v, want %#v", got.Alarms, tt.want.Alarms)
+            }
+        })
+    }
+}
+
+func checkFloat(t *testing.T, name string, got, want *float64) {
+    t.Helper()
+    switch {
+    case got == nil && want == nil:
+    case got == nil || want == nil:
+        t.Errorf("%s = %v, want %v", name, got, want)
+    case math.Abs(*got-*want) > 0.01:
+        t.Errorf("%s = %v, want %v", name, *got, *want)
+    }
+}
+
+func checkInt(t *testing.T, name string, got, want *int64) {
+    t.Helper()
+    switch {
+    case got == nil && want == nil:
+    case got == nil || want == nil:
+        t.Errorf("%s = %v, want %v", name, got, want)
+    case *got != *want:
+        t.Errorf("%s = %d, want %d", name, *got, *want)
+    }
+}
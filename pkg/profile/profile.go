@@ -0,0 +1,204 @@
+package profile
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/parser"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Wildcard matches any vendor or node type in a profile
+const Wildcard = "*"
+
+// Command is a named command run during a health check. Parser names
+// the output parser registered in the parser package, if any.
+type Command struct {
+    Name    string `yaml:"name" json:"name"`
+    Command string `yaml:"command" json:"command"`
+    Parser  string `yaml:"parser" json:"parser,omitempty"`
+}
+
+// Profile is the command set for a vendor and node type
+type Profile struct {
+    Vendor   string    `yaml:"vendor"`
+    NodeType string    `yaml:"node_type"`
+    Commands []Command `yaml:"commands"`
+}
+
+type key struct {
+    vendor   string
+    nodeType string
+}
+
+// Registry holds command profiles keyed by vendor/node_type
+type Registry struct {
+    profiles map[key]*Profile
+}
+
+// NewRegistry creates a registry from a list of profiles
+func NewRegistry(profiles []*Profile) (*Registry, error) {
+    r := &Registry{
+        profiles: make(map[key]*Profile),
+    }
+
+    for _, p := range profiles {
+        if p.Vendor == "" {
+            return nil, fmt.Errorf("profile without vendor")
+        }
+        if p.NodeType == "" {
+            p.NodeType = Wildcard
+        }
+
+        k := key{normalize(p.Vendor), normalize(p.NodeType)}
+        if _, ok := r.profiles[k]; ok {
+            return nil, fmt.Errorf("duplicate profile %s/%s", p.Vendor, p.NodeType)
+        }
+
+        seen := make(map[string]bool)
+        for _, c := range p.Commands {
+            if c.Name == "" || c.Command == "" {
+                return nil, fmt.Errorf("profile %s/%s: command needs a name and a command", p.Vendor, p.NodeType)
+            }
+            if seen[c.Name] {
+                return nil, fmt.Errorf("profile %s/%s: duplicate command %q", p.Vendor, p.NodeType, c.Name)
+            }
+            seen[c.Name] = true
+
+            if c.Parser != "" {
+                if _, ok := parser.Get(c.Parser); !ok {
+                    return nil, fmt.Errorf("profile %s/%s: unknown parser %q", p.Vendor, p.NodeType, c.Parser)
+                }
+            }
+        }
+
+        r.profiles[k] = p
+    }
+
+    return r, nil
+}
+
+// Load reads a command profile file
+func Load(path string) (*Registry, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %w", path, err)
+    }
+
+    var doc struct {
+        Profiles []*Profile `yaml:"profiles"`
+    }
+    if err := yaml.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+    }
+
+    r, err := NewRegistry(doc.Profiles)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+
+    return r, nil
+}
+
+// Lookup returns the most specific profile for a vendor and node type,
+// falling back to the vendor wildcard and then the default profile
+func (r *Registry) Lookup(vendor, nodeType string) (*Profile, bool) {
+    vendor, nodeType = normalize(vendor), normalize(nodeType)
+
+    for _, k := range []key{
+        {vendor, nodeType},
+        {vendor, Wildcard},
+        {"default", nodeType},
+        {"default", Wildcard},
+    } {
+        if p, ok := r.profiles[k]; ok {
+            return p, true
+        }
+    }
+
+    return nil, false
+}
+
+// CommandsFor returns the commands to run on a node: its profile merged
+// with the node's custom_commands.
+//
+// custom_commands is either a JSON array of commands appended to the
+// profile, or a JSON object mapping command names to commands, which
+// replaces same-named profile commands, adds new ones, and removes a
+// command when the value is empty.
+func (r *Registry) CommandsFor(node *inventory.Node) ([]Command, error) {
+    var commands []Command
+    if p, ok := r.Lookup(node.Vendor, node.NodeType); ok {
+        commands = append(commands, p.Commands...)
+    }
+
+    if len(node.CustomCommands) > 0 && string(node.CustomCommands) != "null" {
+        var err error
+        commands, err = merge(commands, node.CustomCommands)
+        if err != nil {
+            return nil, fmt.Errorf("invalid custom_commands for %s: %w", node.NeID, err)
+        }
+    }
+
+    if len(commands) == 0 {
+        return nil, fmt.Errorf("no commands for %s (%s/%s)", node.NeID, node.Vendor, node.NodeType)
+    }
+
+    return commands, nil
+}
+
+// merge applies custom_commands overrides to commands
+func merge(commands []Command, custom []byte) ([]Command, error) {
+    var list []string
+    if err := json.Unmarshal(custom, &list); err == nil {
+        for i, c := range list {
+            commands = append(commands, Command{
+                Name:    fmt.Sprintf("custom_%d", i+1),
+                Command: c,
+            })
+        }
+        return commands, nil
+    }
+
+    var overrides map[string]string
+    if err := json.Unmarshal(custom, &overrides); err != nil {
+        return nil, fmt.Errorf("expected an array or object of commands")
+    }
+
+    merged := make([]Command, 0, len(commands)+len(overrides))
+    applied := make(map[string]bool)
+    for _, c := range commands {
+        if cmd, ok := overrides[c.Name]; ok {
+            applied[c.Name] = true
+            if cmd == "" {
+                continue
+            }
+            c.Command = cmd
+        }
+        merged = append(merged, c)
+    }
+
+    // New commands are appended in name order so the result is stable
+    var names []string
+    for name, cmd := range overrides {
+        if !applied[name] && cmd != "" {
+            names = append(names, name)
+        }
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        merged = append(merged, Command{Name: name, Command: overrides[name]})
+    }
+
+    return merged, nil
+}
+
+func normalize(s string) string {
+    return strings.ToLower(strings.TrimSpace(s))
+}
+
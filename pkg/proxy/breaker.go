@@ -0,0 +1,200 @@
+package proxy
+
+import (
+    "sync"
+    "time"
+)
+
+// BreakerState is the state of a proxy's circuit breaker
+type BreakerState string
+
+const (
+    BreakerClosed   BreakerState = "closed"
+    BreakerOpen     BreakerState = "open"
+    BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig holds circuit breaker settings
+type BreakerConfig struct {
+    // Window is the number of recent outcomes kept per proxy
+    Window int
+
+    // MinRequests outcomes must be seen before the breaker can open
+    MinRequests int
+
+    // FailureRatio of the window that opens the breaker, 0 to 1
+    FailureRatio float64
+
+    // Cooldown is how long an open breaker waits before letting a trial
+    // connection through
+    Cooldown time.Duration
+}
+
+// breakers keeps one circuit breaker per proxy. A closed breaker lets
+// connections through and opens once the failure ratio of its window
+// crosses the threshold. After the cooldown it turns half-open and lets a
+// single trial through: success closes it, failure opens it again.
+type breakers struct {
+    cfg BreakerConfig
+    now func() time.Time
+
+    mu      sync.Mutex
+    proxies map[string]*breaker
+}
+
+type breaker struct {
+    state    BreakerState
+    outcomes []bool
+    openedAt time.Time
+    trialAt  time.Time
+}
+
+func newBreakers(cfg BreakerConfig) *breakers {
+    if cfg.Window <= 0 {
+        cfg.Window = 20
+    }
+    if cfg.MinRequests <= 0 {
+        cfg.MinRequests = 5
+    }
+    if cfg.MinRequests > cfg.Window {
+        cfg.MinRequests = cfg.Window
+    }
+    if cfg.FailureRatio <= 0 || cfg.FailureRatio > 1 {
+        cfg.FailureRatio = 0.5
+    }
+    if cfg.Cooldown <= 0 {
+        cfg.Cooldown = time.Minute
+    }
+
+    return &breakers{
+        cfg:     cfg,
+        now:     time.Now,
+        proxies: make(map[string]*breaker),
+    }
+}
+
+// get returns the breaker for a proxy. A proxy seen for the first time
+// (e.g. after a restart) starts open if its stored success_rate is below
+// the threshold and it failed within the cooldown.
+func (b *breakers) get(px *Proxy) *breaker {
+    br, ok := b.proxies[px.Name]
+    if ok {
+        return br
+    }
+
+    br = &breaker{state: BreakerClosed}
+    failing := px.TotalAttempts >= b.cfg.MinRequests &&
+        px.SuccessRate < (1-b.cfg.FailureRatio)*100
+    if failing && px.LastFailure != nil && b.now().Sub(*px.LastFailure) < b.cfg.Cooldown {
+        br.state = BreakerOpen
+        br.openedAt = *px.LastFailure
+    }
+    b.proxies[px.Name] = br
+    return br
+}
+
+// ready reports whether a connection through px would be let through,
+// without reserving the half-open trial
+func (b *breakers) ready(px *Proxy) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    br := b.get(px)
+    switch br.state {
+    case BreakerOpen:
+        return b.now().Sub(br.openedAt) >= b.cfg.Cooldown
+    case BreakerHalfOpen:
+        // A trial that never reported back does not block forever
+        return b.now().Sub(br.trialAt) >= b.cfg.Cooldown
+    }
+    return true
+}
+
+// allow reports whether a connection through px may be made and, for an
+// open breaker past its cooldown, reserves the trial connection
+func (b *breakers) allow(px *Proxy) bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    br := b.get(px)
+    now := b.now()
+    switch br.state {
+    case BreakerOpen:
+        if now.Sub(br.openedAt) < b.cfg.Cooldown {
+            return false
+        }
+    case BreakerHalfOpen:
+        if now.Sub(br.trialAt) < b.cfg.Cooldown {
+            return false
+        }
+    default:
+        return true
+    }
+
+    br.state = BreakerHalfOpen
+    br.trialAt = now
+    return true
+}
+
+// record feeds a connection outcome to the proxy's breaker
+func (b *breakers) record(name string, success bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    br, ok := b.proxies[name]
+    if !ok {
+        br = &breaker{state: BreakerClosed}
+        b.proxies[name] = br
+    }
+
+    switch br.state {
+    case BreakerHalfOpen:
+        if success {
+            br.state = BreakerClosed
+            br.outcomes = br.outcomes[:0]
+        } else {
+            br.state = BreakerOpen
+            br.openedAt = b.now()
+        }
+        return
+    case BreakerOpen:
+        // Outcomes reported while open (e.g. connections made before it
+        // opened) do not change the state
+        return
+    }
+
+    br.outcomes = append(br.outcomes, success)
+    if len(br.outcomes) > b.cfg.Window {
+        br.outcomes = br.outcomes[len(br.outcomes)-b.cfg.Window:]
+    }
+    if len(br.outcomes) < b.cfg.MinRequests {
+        return
+    }
+
+    failures := 0
+    for _, ok := range br.outcomes {
+        if !ok {
+            failures++
+        }
+    }
+    if float64(failures)/float64(len(br.outcomes)) >= b.cfg.FailureRatio {
+        br.state = BreakerOpen
+        br.openedAt = b.now()
+        br.outcomes = br.outcomes[:0]
+    }
+}
+
+// state returns the current state of a proxy's breaker
+func (b *breakers) state(name string) BreakerState {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    br, ok := b.proxies[name]
+    if !ok {
+        return BreakerClosed
+    }
+    if br.state == BreakerOpen && b.now().Sub(br.openedAt) >= b.cfg.Cooldown {
+        return BreakerHalfOpen
+    }
+    return br.state
+}
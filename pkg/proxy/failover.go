@@ -0,0 +1,210 @@
+package proxy
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// FailoverConfig holds the retry settings used by Connect
+type FailoverConfig struct {
+    // RetryAttempts is the number of passes over the proxy list
+    RetryAttempts int
+    RetryDelay    time.Duration
+}
+
+// ConnectFunc makes a connection through a proxy. Returning an error
+// wrapped with Downstream means the proxy itself worked.
+type ConnectFunc func(ctx context.Context, px *Proxy) error
+
+// Attempt is one connection attempt made by Connect
+type Attempt struct {
+    Proxy    string
+    Pass     int
+    Duration time.Duration
+    Err      error
+}
+
+// FailoverError lists every attempt of a failed Connect
+type FailoverError struct {
+    Attempts []Attempt
+
+    // Err is set when Connect stopped early because ctx was done
+    Err error
+}
+
+func (e *FailoverError) Error() string {
+    var b strings.Builder
+    if e.Err != nil {
+        fmt.Fprintf(&b, "proxy connect stopped: %v", e.Err)
+    } else {
+        fmt.Fprintf(&b, "all proxies failed")
+    }
+    if len(e.Attempts) > 0 {
+        b.WriteString(" (tried")
+        for i, a := range e.Attempts {
+            if i > 0 {
+                b.WriteString(";")
+            }
+            name := a.Proxy
+            if name == "" {
+                name = "<none>"
+            }
+            fmt.Fprintf(&b, " %s pass %d: %v", name, a.Pass, a.Err)
+        }
+        b.WriteString(")")
+    }
+    return b.String()
+}
+
+// Unwrap returns the stop error and the error of every attempt
+func (e *FailoverError) Unwrap() []error {
+    errs := make([]error, 0, len(e.Attempts)+1)
+    if e.Err != nil {
+        errs = append(errs, e.Err)
+    }
+    for _, a := range e.Attempts {
+        errs = append(errs, a.Err)
+    }
+    return errs
+}
+
+type downstreamError struct {
+    err error
+}
+
+func (e *downstreamError) Error() string { return e.err.Error() }
+
+func (e *downstreamError) Unwrap() error { return e.err }
+
+// Downstream marks an error as happening past the proxy (e.g. on the NIAM
+// or node hop). Connect counts the proxy as working, does not try other
+// proxies and returns the unwrapped error.
+func Downstream(err error) error {
+    if err == nil {
+        return nil
+    }
+    return &downstreamError{err: err}
+}
+
+// SetFailover sets the retry settings used by Connect
+func (m *Manager) SetFailover(cfg FailoverConfig) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.failover = cfg
+}
+
+// Connect calls fn with each available proxy in strategy order until one
+// succeeds, making up to RetryAttempts passes over the list with
+// RetryDelay between passes. Every attempt is recorded and counts a
+// connection against its proxy. The proxy that worked is returned and its
+// connection must be given back with Release.
+func (m *Manager) Connect(ctx context.Context, fn ConnectFunc) (*Proxy, error) {
+    m.mu.Lock()
+    cfg := m.failover
+    m.mu.Unlock()
+    if cfg.RetryAttempts <= 0 {
+        cfg.RetryAttempts = 1
+    }
+
+    failed := &FailoverError{}
+    for pass := 1; pass <= cfg.RetryAttempts; pass++ {
+        if pass > 1 && cfg.RetryDelay > 0 {
+            select {
+            case <-ctx.Done():
+                failed.Err = ctx.Err()
+                return nil, failed
+            case <-time.After(cfg.RetryDelay):
+            }
+        }
+
+        ordered, err := m.connectCandidates()
+        if err != nil {
+            failed.Attempts = append(failed.Attempts, Attempt{Pass: pass, Err: err})
+            continue
+        }
+
+        for _, px := range ordered {
+            if ctx.Err() != nil {
+                failed.Err = ctx.Err()
+                return nil, failed
+            }
+
+            ok, err := m.reserve(px)
+            if err != nil {
+                return nil, err
+            }
+            if !ok {
+                continue
+            }
+
+            start := time.Now()
+            connErr := fn(ctx, px)
+
+            // A cancelled check is not the proxy's fault
+            if connErr != nil && ctx.Err() != nil {
+                m.release(px.Name)
+                failed.Err = ctx.Err()
+                return nil, failed
+            }
+
+            var downstream *downstreamError
+            if connErr == nil || errors.As(connErr, &downstream) {
+                if err := m.RecordSuccess(px.Name); err != nil {
+                    log.Printf("Failed to record proxy result for %s: %v", px.Name, err)
+                }
+                if connErr == nil {
+                    px.CurrentConnections++
+                    return px, nil
+                }
+                m.release(px.Name)
+                return nil, downstream.err
+            }
+
+            if err := m.RecordFailure(px.Name); err != nil {
+                log.Printf("Failed to record proxy result for %s: %v", px.Name, err)
+            }
+            m.release(px.Name)
+            failed.Attempts = append(failed.Attempts, Attempt{
+                Proxy:    px.Name,
+                Pass:     pass,
+                Duration: time.Since(start),
+                Err:      connErr,
+            })
+            log.Printf("Connection via proxy %s failed (pass %d/%d): %v", px.Name, pass, cfg.RetryAttempts, connErr)
+        }
+    }
+
+    return nil, failed
+}
+
+// connectCandidates returns the ordered candidates for one Connect pass
+func (m *Manager) connectCandidates() ([]*Proxy, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return m.candidates()
+}
+
+// reserve checks the proxy's breaker and counts a connection against it.
+// It reports false if the breaker no longer lets connections through.
+func (m *Manager) reserve(px *Proxy) (bool, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.breakers != nil && !m.breakers.allow(px) {
+        return false, nil
+    }
+    if err := m.addConnection(px.Name); err != nil {
+        return false, fmt.Errorf("failed to reserve proxy %s: %w", px.Name, err)
+    }
+    return true, nil
+}
+
+func (m *Manager) release(proxyName string) {
+    if err := m.Release(proxyName); err != nil {
+        log.Printf("Failed to release proxy %s: %v", proxyName, err)
+    }
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DialFunc opens a TCP connection to a proxy
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ProberConfig holds proxy probing settings
+type ProberConfig struct {
+    Interval time.Duration
+    Timeout  time.Duration
+
+    // FailureThreshold consecutive failures deactivate a proxy and
+    // RecoveryThreshold consecutive successes reactivate it
+    FailureThreshold  int
+    RecoveryThreshold int
+
+    Dial DialFunc
+}
+
+// Prober periodically checks that every Mito proxy answers an SSH
+// handshake and flips is_active accordingly. Proxies deactivated by an
+// operator are neither probed nor reactivated. Probe results are counted
+// in the proxy statistics but do not feed the circuit breakers, which
+// only see real connections.
+type Prober struct {
+    m   *Manager
+    cfg ProberConfig
+
+    mu        sync.Mutex
+    failures  map[string]int
+    successes map[string]int
+}
+
+// NewProber creates a new proxy prober
+func NewProber(m *Manager, cfg ProberConfig) *Prober {
+    if cfg.Interval <= 0 {
+        cfg.Interval = time.Minute
+    }
+    if cfg.Timeout <= 0 {
+        cfg.Timeout = 10 * time.Second
+    }
+    if cfg.FailureThreshold <= 0 {
+        cfg.FailureThreshold = 3
+    }
+    if cfg.RecoveryThreshold <= 0 {
+        cfg.RecoveryThreshold = 1
+    }
+    if cfg.Dial == nil {
+        dialer := &net.Dialer{}
+        cfg.Dial = dialer.DialContext
+    }
+
+    return &Prober{
+        m:         m,
+        cfg:       cfg,
+        failures:  make(map[string]int),
+        successes: make(map[string]int),
+    }
+}
+
+// Run probes all proxies every interval until ctx is done
+func (p *Prober) Run(ctx context.Context) {
+    ticker := time.NewTicker(p.cfg.Interval)
+    defer ticker.Stop()
+
+    for {
+        if err := p.ProbeAll(ctx); err != nil {
+            log.Printf("Proxy probe failed: %v", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// ProbeAll probes every active proxy and every proxy the prober
+// deactivated, in parallel
+func (p *Prober) ProbeAll(ctx context.Context) error {
+    proxies, err := p.m.listProxies(false)
+    if err != nil {
+        return fmt.Errorf("failed to list proxies: %w", err)
+    }
+
+    var wg sync.WaitGroup
+    for _, px := range proxies {
+        if !px.IsActive && !px.DeactivatedByProbe {
+            continue
+        }
+        wg.Add(1)
+        go func(px *Proxy) {
+            defer wg.Done()
+            p.record(px, p.Probe(ctx, px))
+        }(px)
+    }
+    wg.Wait()
+
+    return nil
+}
+
+// Probe connects to a proxy and exchanges SSH identification strings
+func (p *Prober) Probe(ctx context.Context, px *Proxy) error {
+    ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+    defer cancel()
+
+    addr := net.JoinHostPort(px.IP, strconv.Itoa(px.Port))
+    conn, err := p.cfg.Dial(ctx, "tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+
+    if _, err := conn.Write([]byte("SSH-2.0-HealthCheckProbe\r\n")); err != nil {
+        return err
+    }
+
+    // Servers may send other lines before the identification string
+    r := bufio.NewReader(conn)
+    for i := 0; i < 10; i++ {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return fmt.Errorf("no SSH banner from %s: %w", addr, err)
+        }
+        if strings.HasPrefix(line, "SSH-") {
+            return nil
+        }
+    }
+
+    return fmt.Errorf("no SSH banner from %s", addr)
+}
+
+// record stores a probe result and (de)activates the proxy once a
+// threshold is reached
+func (p *Prober) record(px *Proxy, probeErr error) {
+    p.mu.Lock()
+    if probeErr != nil {
+        p.failures[px.Name]++
+        p.successes[px.Name] = 0
+    } else {
+        p.successes[px.Name]++
+        p.failures[px.Name] = 0
+    }
+    failures, successes := p.failures[px.Name], p.successes[px.Name]
+    p.mu.Unlock()
+
+    // Probes count in the proxy's statistics but not in its breaker
+    if err := p.m.recordStats(px.Name, probeErr == nil); err != nil {
+        log.Printf("Failed to record probe result for %s: %v", px.Name, err)
+    }
+
+    var changed bool
+    var err error
+    switch {
+    case probeErr != nil && px.IsActive && failures >= p.cfg.FailureThreshold:
+        if changed, err = p.m.deactivateByProbe(px.Name); changed {
+            log.Printf("Deactivated proxy %s after %d failed probes: %v", px.Name, failures, probeErr)
+        }
+    case probeErr == nil && px.DeactivatedByProbe && successes >= p.cfg.RecoveryThreshold:
+        if changed, err = p.m.reactivateByProbe(px.Name); changed {
+            log.Printf("Reactivated proxy %s", px.Name)
+        }
+    }
+    if err != nil {
+        log.Printf("Failed to update proxy %s: %v", px.Name, err)
+    }
+}
@@ -4,95 +4,266 @@ import (
     "database/sql"
     "fmt"
     "sync"
+    "time"
 )
 
 // Proxy represents a Mito proxy server
 type Proxy struct {
-    Name     string
-    IP       string
-    Port     int
-    User     string
-    Priority int
-    IsPrimary bool
+    Name               string     `json:"name"`
+    IP                 string     `json:"ip"`
+    Port               int        `json:"port"`
+    User               string     `json:"user"`
+    Priority           int        `json:"priority"`
+    IsPrimary          bool       `json:"is_primary"`
+    IsActive           bool       `json:"is_active"`
+    DeactivatedByProbe bool       `json:"deactivated_by_probe"`
+    CurrentConnections int        `json:"current_connections"`
+    MaxConnections     int        `json:"max_connections"`
+    TotalAttempts      int        `json:"total_attempts"`
+    SuccessRate        float64    `json:"success_rate"`
+    LastFailure        *time.Time `json:"last_failure"`
 }
 
 // Manager manages Mito proxy pool
 type Manager struct {
-    db *sql.DB
-    mu sync.Mutex
+    db       *sql.DB
+    mu       sync.Mutex
+    strategy Strategy
+    breakers *breakers
+    failover FailoverConfig
 }
 
-// NewManager creates a new proxy manager
+// NewManager creates a new proxy manager using the failover strategy
 func NewManager(db *sql.DB) *Manager {
     return &Manager{
-        db: db,
+        db:       db,
+        strategy: Failover{},
     }
 }
 
-// GetProxy gets the best available proxy (failover support)
+// SetStrategy sets how GetProxy and Acquire choose between proxies
+func (m *Manager) SetStrategy(s Strategy) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.strategy = s
+}
+
+// SetBreaker enables a circuit breaker per proxy. Proxies whose breaker
+// is open are skipped by GetProxy and Acquire.
+func (m *Manager) SetBreaker(cfg BreakerConfig) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.breakers = newBreakers(cfg)
+}
+
+// BreakerState returns the state of a proxy's circuit breaker
+func (m *Manager) BreakerState(proxyName string) BreakerState {
+    if m.breakers == nil {
+        return BreakerClosed
+    }
+    return m.breakers.state(proxyName)
+}
+
+// GetProxy gets the best available proxy according to the strategy. It
+// only looks: the trial connection of a half-open breaker is left for
+// Acquire or Connect.
 func (m *Manager) GetProxy() (*Proxy, error) {
     m.mu.Lock()
     defer m.mu.Unlock()
 
-    query := `
-        SELECT proxy_name, proxy_ip, proxy_port, proxy_user, priority, is_primary
-        FROM hc_mito_proxies
-        WHERE is_active = TRUE
-        ORDER BY priority ASC
-        LIMIT 1
-    `
+    ordered, err := m.candidates()
+    if err != nil {
+        return nil, err
+    }
+
+    return ordered[0], nil
+}
+
+// Acquire gets the best available proxy and counts a connection against
+// it. Release must be called when the connection is closed.
+func (m *Manager) Acquire() (*Proxy, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
 
-    proxy := &Proxy{}
-    err := m.db.QueryRow(query).Scan(
-        &proxy.Name,
-        &proxy.IP,
-        &proxy.Port,
-        &proxy.User,
-        &proxy.Priority,
-        &proxy.IsPrimary,
-    )
+    ordered, err := m.candidates()
     if err != nil {
-        return nil, fmt.Errorf("no available proxy: %w", err)
+        return nil, err
     }
 
-    return proxy, nil
+    px, err := m.pick(ordered)
+    if err != nil {
+        return nil, err
+    }
+    if err := m.addConnection(px.Name); err != nil {
+        return nil, err
+    }
+    px.CurrentConnections++
+
+    return px, nil
 }
 
-// RecordSuccess records successful proxy usage
-func (m *Manager) RecordSuccess(proxyName string) error {
+// Release returns a connection slot to a proxy
+func (m *Manager) Release(proxyName string) error {
     _, err := m.db.Exec(`
         UPDATE hc_mito_proxies
-        SET total_attempts = total_attempts + 1,
-            last_success = NOW(),
-            success_rate = ((total_attempts - failed_attempts) * 100.0) / (total_attempts + 1)
+        SET current_connections = GREATEST(current_connections - 1, 0)
         WHERE proxy_name = ?
     `, proxyName)
     return err
 }
 
+func (m *Manager) addConnection(proxyName string) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_mito_proxies
+        SET current_connections = current_connections + 1
+        WHERE proxy_name = ?
+    `, proxyName)
+    return err
+}
+
+// candidates returns the active proxies with spare connections and a
+// breaker that lets connections through, ordered by the strategy. The
+// caller must hold m.mu.
+func (m *Manager) candidates() ([]*Proxy, error) {
+    proxies, err := m.listProxies(true)
+    if err != nil {
+        return nil, fmt.Errorf("no available proxy: %w", err)
+    }
+
+    var available []*Proxy
+    full, open := 0, 0
+    for _, px := range proxies {
+        switch {
+        case px.MaxConnections > 0 && px.CurrentConnections >= px.MaxConnections:
+            full++
+        case m.breakers != nil && !m.breakers.ready(px):
+            open++
+        default:
+            available = append(available, px)
+        }
+    }
+    if len(available) == 0 {
+        return nil, fmt.Errorf("no available proxy: %d active, %d at max_connections, %d with open circuit breaker",
+            len(proxies), full, open)
+    }
+
+    return m.strategy.Order(available), nil
+}
+
+// pick returns the first ordered proxy its breaker allows, reserving the
+// trial connection of a half-open breaker. The caller must hold m.mu.
+func (m *Manager) pick(ordered []*Proxy) (*Proxy, error) {
+    for _, px := range ordered {
+        if m.breakers == nil || m.breakers.allow(px) {
+            return px, nil
+        }
+    }
+    return nil, fmt.Errorf("no available proxy: all circuit breakers open")
+}
+
+// RecordSuccess records successful proxy usage
+func (m *Manager) RecordSuccess(proxyName string) error {
+    if m.breakers != nil {
+        m.breakers.record(proxyName, true)
+    }
+    return m.recordStats(proxyName, true)
+}
+
 // RecordFailure records failed proxy usage
 func (m *Manager) RecordFailure(proxyName string) error {
+    if m.breakers != nil {
+        m.breakers.record(proxyName, false)
+    }
+    return m.recordStats(proxyName, false)
+}
+
+// recordStats updates the attempt counters of a proxy without feeding its
+// circuit breaker. MySQL applies the SET assignments in order, so
+// success_rate sees the updated counters.
+func (m *Manager) recordStats(proxyName string, success bool) error {
+    if success {
+        _, err := m.db.Exec(`
+            UPDATE hc_mito_proxies
+            SET total_attempts = total_attempts + 1,
+                last_success = NOW(),
+                success_rate = ((total_attempts - failed_attempts) * 100.0) / total_attempts
+            WHERE proxy_name = ?
+        `, proxyName)
+        return err
+    }
+
     _, err := m.db.Exec(`
         UPDATE hc_mito_proxies
         SET total_attempts = total_attempts + 1,
             failed_attempts = failed_attempts + 1,
             last_failure = NOW(),
-            success_rate = ((total_attempts - failed_attempts - 1) * 100.0) / (total_attempts + 1)
+            success_rate = ((total_attempts - failed_attempts) * 100.0) / total_attempts
         WHERE proxy_name = ?
     `, proxyName)
     return err
 }
 
+// SetActive activates or deactivates a proxy. A proxy deactivated here
+// stays inactive until it is activated again; the prober leaves it alone.
+func (m *Manager) SetActive(proxyName string, active bool) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_mito_proxies
+        SET is_active = ?, deactivated_by_probe = FALSE
+        WHERE proxy_name = ?
+    `, active, proxyName)
+    return err
+}
+
+// deactivateByProbe deactivates an active proxy on behalf of the prober
+func (m *Manager) deactivateByProbe(proxyName string) (bool, error) {
+    res, err := m.db.Exec(`
+        UPDATE hc_mito_proxies
+        SET is_active = FALSE, deactivated_by_probe = TRUE
+        WHERE proxy_name = ? AND is_active = TRUE
+    `, proxyName)
+    if err != nil {
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
+// reactivateByProbe reactivates a proxy only if the prober deactivated it
+func (m *Manager) reactivateByProbe(proxyName string) (bool, error) {
+    res, err := m.db.Exec(`
+        UPDATE hc_mito_proxies
+        SET is_active = TRUE, deactivated_by_probe = FALSE
+        WHERE proxy_name = ? AND deactivated_by_probe = TRUE
+    `, proxyName)
+    if err != nil {
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
+}
+
 // GetAllProxies returns all active proxies in priority order
 func (m *Manager) GetAllProxies() ([]*Proxy, error) {
+    return m.listProxies(true)
+}
+
+// ListProxies returns every proxy, including inactive ones, in priority order
+func (m *Manager) ListProxies() ([]*Proxy, error) {
+    return m.listProxies(false)
+}
+
+// listProxies returns proxies in priority order, optionally including
+// inactive ones
+func (m *Manager) listProxies(activeOnly bool) ([]*Proxy, error) {
     query := `
-        SELECT proxy_name, proxy_ip, proxy_port, proxy_user, priority, is_primary
+        SELECT proxy_name, proxy_ip, proxy_port, proxy_user, priority, is_primary, is_active,
+               COALESCE(deactivated_by_probe, FALSE), current_connections, max_connections, total_attempts, success_rate, last_failure
         FROM hc_mito_proxies
-        WHERE is_active = TRUE
+        WHERE is_active = TRUE OR ? = FALSE
         ORDER BY priority ASC
     `
 
-    rows, err := m.db.Query(query)
+    rows, err := m.db.Query(query, activeOnly)
     if err != nil {
         return nil, err
     }
@@ -108,6 +279,13 @@ func (m *Manager) GetAllProxies() ([]*Proxy, error) {
             &proxy.User,
             &proxy.Priority,
             &proxy.IsPrimary,
+            &proxy.IsActive,
+            &proxy.DeactivatedByProbe,
+            &proxy.CurrentConnections,
+            &proxy.MaxConnections,
+            &proxy.TotalAttempts,
+            &proxy.SuccessRate,
+            &proxy.LastFailure,
         )
         if err != nil {
             return nil, err
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Strategy orders the available proxies by preference. Candidates are
+// passed in priority order and the first proxy returned is used.
+type Strategy interface {
+    Name() string
+    Order(candidates []*Proxy) []*Proxy
+}
+
+// NewStrategy returns the strategy named in infrastructure.yaml:
+// failover, round_robin or least_connections
+func NewStrategy(name string) (Strategy, error) {
+    switch strings.ReplaceAll(strings.ToLower(name), "-", "_") {
+    case "", "failover":
+        return Failover{}, nil
+    case "round_robin", "weighted_round_robin":
+        return NewRoundRobin(), nil
+    case "least_connections":
+        return LeastConnections{}, nil
+    }
+    return nil, fmt.Errorf("unknown proxy strategy %q", name)
+}
+
+// Failover always prefers the lowest priority number
+type Failover struct{}
+
+func (Failover) Name() string { return "failover" }
+
+func (Failover) Order(candidates []*Proxy) []*Proxy {
+    return candidates
+}
+
+// RoundRobin spreads selections over the proxies in proportion to their
+// max_connections (smooth weighted round-robin)
+type RoundRobin struct {
+    mu      sync.Mutex
+    current map[string]int
+}
+
+// NewRoundRobin creates a weighted round-robin strategy
+func NewRoundRobin() *RoundRobin {
+    return &RoundRobin{
+        current: make(map[string]int),
+    }
+}
+
+func (*RoundRobin) Name() string { return "round_robin" }
+
+func (r *RoundRobin) Order(candidates []*Proxy) []*Proxy {
+    if len(candidates) == 0 {
+        return candidates
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    total := 0
+    best := 0
+    for i, px := range candidates {
+        w := weight(px)
+        total += w
+        r.current[px.Name] += w
+        if r.current[px.Name] > r.current[candidates[best].Name] {
+            best = i
+        }
+    }
+    r.current[candidates[best].Name] -= total
+
+    // The selected proxy goes first, the rest keep priority order as
+    // fallbacks
+    ordered := make([]*Proxy, 0, len(candidates))
+    ordered = append(ordered, candidates[best])
+    ordered = append(ordered, candidates[:best]...)
+    ordered = append(ordered, candidates[best+1:]...)
+    return ordered
+}
+
+func weight(px *Proxy) int {
+    if px.MaxConnections > 0 {
+        return px.MaxConnections
+    }
+    return 1
+}
+
+// LeastConnections prefers the proxy with the lowest share of its
+// max_connections in use, breaking ties by priority
+type LeastConnections struct{}
+
+func (LeastConnections) Name() string { return "least_connections" }
+
+func (LeastConnections) Order(candidates []*Proxy) []*Proxy {
+    ordered := make([]*Proxy, len(candidates))
+    copy(ordered, candidates)
+
+    sort.SliceStable(ordered, func(i, j int) bool {
+        return load(ordered[i]) < load(ordered[j])
+    })
+    return ordered
+}
+
+func load(px *Proxy) float64 {
+    if px.MaxConnections <= 0 {
+        return float64(px.CurrentConnections)
+    }
+    return float64(px.CurrentConnections) / float64(px.MaxConnections)
+}
@@ -0,0 +1,203 @@
+package scoring
+
+import (
+    "fmt"
+    "math"
+    "os"
+
+    "health-check-system/pkg/parser"
+
+    "gopkg.in/yaml.v3"
+)
+
+// MaxScore is the score of a node no rule penalised
+const MaxScore = 100
+
+// Metric names a value rules can be evaluated against
+type Metric string
+
+const (
+    MetricCPU             Metric = "cpu_percent"
+    MetricMemory          Metric = "memory_percent"
+    MetricInterfaceErrors Metric = "interface_errors"
+    MetricUptime          Metric = "uptime_seconds"
+    MetricCriticalAlarms  Metric = "critical_alarms"
+    MetricMajorAlarms     Metric = "major_alarms"
+    MetricMinorAlarms     Metric = "minor_alarms"
+    MetricFailedCommands  Metric = "failed_commands"
+)
+
+// Rule deducts points when a metric crosses a threshold
+type Rule struct {
+    Name      string  `yaml:"name" json:"name"`
+    Metric    Metric  `yaml:"metric" json:"metric"`
+    Op        string  `yaml:"op" json:"op"`
+    Threshold float64 `yaml:"threshold" json:"threshold"`
+    Penalty   float64 `yaml:"penalty" json:"penalty"`
+
+    // PerUnit multiplies the penalty by how far the value is past the
+    // threshold, up to MaxPenalty
+    PerUnit    bool    `yaml:"per_unit" json:"per_unit,omitempty"`
+    MaxPenalty float64 `yaml:"max_penalty" json:"max_penalty,omitempty"`
+}
+
+// RuleResult is the outcome of one rule for one check
+type RuleResult struct {
+    Rule      string   `json:"rule"`
+    Metric    Metric   `json:"metric"`
+    Value     *float64 `json:"value"`
+    Triggered bool     `json:"triggered"`
+    Penalty   float64  `json:"penalty"`
+    Reason    string   `json:"reason"`
+}
+
+// Result is a health score with the per-rule breakdown
+type Result struct {
+    Score     int          `json:"score"`
+    Breakdown []RuleResult `json:"breakdown"`
+}
+
+// Engine scores parsed metrics against a set of rules
+type Engine struct {
+    rules []Rule
+}
+
+// NewEngine creates a scoring engine
+func NewEngine(rules []Rule) (*Engine, error) {
+    for _, r := range rules {
+        if r.Name == "" {
+            return nil, fmt.Errorf("rule without name")
+        }
+        if !known(r.Metric) {
+            return nil, fmt.Errorf("rule %s: unknown metric %q", r.Name, r.Metric)
+        }
+        if r.Op != ">" && r.Op != "<" {
+            return nil, fmt.Errorf("rule %s: op must be \">\" or \"<\"", r.Name)
+        }
+        if r.Penalty < 0 {
+            return nil, fmt.Errorf("rule %s: penalty must not be negative", r.Name)
+        }
+    }
+
+    return &Engine{
+        rules: rules,
+    }, nil
+}
+
+// Load reads scoring rules from a YAML file
+func Load(path string) (*Engine, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read %s: %w", path, err)
+    }
+
+    var doc struct {
+        Rules []Rule `yaml:"rules"`
+    }
+    if err := yaml.Unmarshal(data, &doc); err != nil {
+        return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+    }
+
+    e, err := NewEngine(doc.Rules)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+
+    return e, nil
+}
+
+// Score evaluates every rule against m. Rules whose metric was not
+// collected are listed in the breakdown but do not deduct points.
+func (e *Engine) Score(m *parser.Metrics) *Result {
+    res := &Result{
+        Breakdown: make([]RuleResult, 0, len(e.rules)),
+    }
+
+    total := 0.0
+    for _, r := range e.rules {
+        rr := RuleResult{
+            Rule:   r.Name,
+            Metric: r.Metric,
+        }
+
+        value, ok := lookup(r.Metric, m)
+        if !ok {
+            rr.Reason = "not collected"
+            res.Breakdown = append(res.Breakdown, rr)
+            continue
+        }
+        rr.Value = &value
+
+        over := value - r.Threshold
+        if r.Op == "<" {
+            over = r.Threshold - value
+        }
+
+        if over > 0 {
+            rr.Triggered = true
+            rr.Penalty = r.Penalty
+            if r.PerUnit {
+                rr.Penalty = r.Penalty * over
+                if r.MaxPenalty > 0 && rr.Penalty > r.MaxPenalty {
+                    rr.Penalty = r.MaxPenalty
+                }
+            }
+            rr.Reason = fmt.Sprintf("%s %g %s %g", r.Metric, value, r.Op, r.Threshold)
+        } else {
+            rr.Reason = "ok"
+        }
+
+        total += rr.Penalty
+        res.Breakdown = append(res.Breakdown, rr)
+    }
+
+    res.Score = int(math.Round(math.Max(0, MaxScore-total)))
+    return res
+}
+
+// known reports whether metric is one the engine understands
+func known(metric Metric) bool {
+    switch metric {
+    case MetricCPU, MetricMemory, MetricInterfaceErrors, MetricUptime,
+        MetricCriticalAlarms, MetricMajorAlarms, MetricMinorAlarms, MetricFailedCommands:
+        return true
+    }
+    return false
+}
+
+// lookup returns the value of metric in m, if it was collected
+func lookup(metric Metric, m *parser.Metrics) (float64, bool) {
+    switch metric {
+    case MetricCPU:
+        if m.CPUPercent != nil {
+            return *m.CPUPercent, true
+        }
+    case MetricMemory:
+        if m.MemoryPercent != nil {
+            return *m.MemoryPercent, true
+        }
+    case MetricInterfaceErrors:
+        if m.InterfaceErrors != nil {
+            return float64(*m.InterfaceErrors), true
+        }
+    case MetricUptime:
+        if m.UptimeSeconds != nil {
+            return float64(*m.UptimeSeconds), true
+        }
+    case MetricCriticalAlarms, MetricMajorAlarms, MetricMinorAlarms:
+        if m.Alarms == nil {
+            return 0, false
+        }
+        severity := string(metric[:len(metric)-len("_alarms")])
+        n := 0
+        for _, a := range m.Alarms {
+            if a.Severity == severity {
+                n++
+            }
+        }
+        return float64(n), true
+    case MetricFailedCommands:
+        return float64(m.Failed()), true
+    }
+    return 0, false
+}
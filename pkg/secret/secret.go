@@ -0,0 +1,360 @@
+package secret
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Prefix marks a value encrypted by this package. Values without it are
+// treated as plaintext, so existing rows keep working until migrated.
+const Prefix = "enc:v1:"
+
+const keySize = 32
+
+// ErrNoKeyring is returned when an encrypted value is read without a key file
+var ErrNoKeyring = errors.New("encrypted value but no key file loaded")
+
+// ErrUnknownKey is returned for values encrypted with a key that is not
+// in the keyring
+var ErrUnknownKey = errors.New("encrypted with unknown key")
+
+// Keyring holds the key-encryption keys. Every value is encrypted with its
+// own random data key, and the data key is encrypted (wrapped) with the
+// active key. Rotation adds a new active key; old keys stay in the ring
+// until every value has been rewrapped.
+//
+// A keyring loaded from a file rereads it when a value names a key it does
+// not know, so a running process picks up keys added by "keytool rotate".
+type Keyring struct {
+    Active string            `json:"active"`
+    Keys   map[string][]byte `json:"keys"`
+
+    mu      sync.RWMutex
+    path    string
+    modTime time.Time
+}
+
+// NewKeyring creates a keyring with one fresh key
+func NewKeyring() (*Keyring, error) {
+    k := &Keyring{Keys: make(map[string][]byte)}
+    if _, err := k.Rotate(); err != nil {
+        return nil, err
+    }
+    return k, nil
+}
+
+// Load reads a keyring from a key file
+func Load(path string) (*Keyring, error) {
+    k, err := read(path)
+    if err != nil {
+        return nil, err
+    }
+    k.path = path
+    return k, nil
+}
+
+func read(path string) (*Keyring, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read key file: %w", err)
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read key file: %w", err)
+    }
+
+    k := &Keyring{modTime: info.ModTime()}
+    if err := json.Unmarshal(data, k); err != nil {
+        return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+    }
+    if _, ok := k.Keys[k.Active]; !ok {
+        return nil, fmt.Errorf("key file %s: active key %q not found", path, k.Active)
+    }
+    for id, key := range k.Keys {
+        if len(key) != keySize {
+            return nil, fmt.Errorf("key file %s: key %q is %d bytes, want %d", path, id, len(key), keySize)
+        }
+    }
+
+    return k, nil
+}
+
+// reload rereads the key file if it changed since it was loaded. It
+// reports whether the keys were replaced.
+func (k *Keyring) reload() (bool, error) {
+    if k.path == "" {
+        return false, nil
+    }
+
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    info, err := os.Stat(k.path)
+    if err != nil {
+        return false, fmt.Errorf("failed to read key file: %w", err)
+    }
+    if info.ModTime().Equal(k.modTime) {
+        return false, nil
+    }
+
+    fresh, err := read(k.path)
+    if err != nil {
+        return false, err
+    }
+    k.Active, k.Keys, k.modTime = fresh.Active, fresh.Keys, fresh.modTime
+    return true, nil
+}
+
+// Save writes the keyring to a key file readable only by its owner
+func (k *Keyring) Save(path string) error {
+    k.mu.RLock()
+    data, err := json.MarshalIndent(k, "", "  ")
+    k.mu.RUnlock()
+    if err != nil {
+        return err
+    }
+
+    // Write then rename so a crash never leaves a truncated key file
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+        return fmt.Errorf("failed to write key file: %w", err)
+    }
+    return os.Rename(tmp, path)
+}
+
+// Rotate adds a new key and makes it active. It returns the new key ID.
+func (k *Keyring) Rotate() (string, error) {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    key := make([]byte, keySize)
+    if _, err := io.ReadFull(rand.Reader, key); err != nil {
+        return "", err
+    }
+
+    id := "k" + strconv.Itoa(k.nextID())
+    k.Keys[id] = key
+    k.Active = id
+    return id, nil
+}
+
+func (k *Keyring) nextID() int {
+    next := 1
+    for id := range k.Keys {
+        if n, err := strconv.Atoi(strings.TrimPrefix(id, "k")); err == nil && n >= next {
+            next = n + 1
+        }
+    }
+    return next
+}
+
+// Prune removes every key except the active one
+func (k *Keyring) Prune() []string {
+    k.mu.Lock()
+    defer k.mu.Unlock()
+
+    var removed []string
+    for id := range k.Keys {
+        if id != k.Active {
+            delete(k.Keys, id)
+            removed = append(removed, id)
+        }
+    }
+    sort.Strings(removed)
+    return removed
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+    return strings.HasPrefix(value, Prefix)
+}
+
+// KeyID returns the ID of the key an encrypted value is wrapped with
+func KeyID(value string) (string, bool) {
+    if !IsEncrypted(value) {
+        return "", false
+    }
+    id, _, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+    return id, ok
+}
+
+// Encrypt encrypts plaintext under a new data key wrapped with the
+// active key. The result has the form enc:v1:<key id>:<wrapped key>:<data>.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+    if k == nil {
+        return "", ErrNoKeyring
+    }
+
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+    return k.encrypt(plaintext)
+}
+
+// encrypt is Encrypt for callers holding mu
+func (k *Keyring) encrypt(plaintext string) (string, error) {
+    dataKey := make([]byte, keySize)
+    if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+        return "", err
+    }
+
+    wrapped, err := seal(k.Keys[k.Active], dataKey, []byte(k.Active))
+    if err != nil {
+        return "", err
+    }
+    data, err := seal(dataKey, []byte(plaintext), nil)
+    if err != nil {
+        return "", err
+    }
+
+    return Prefix + k.Active + ":" + encode(wrapped) + ":" + encode(data), nil
+}
+
+// Decrypt returns the plaintext of a value. Values without the enc:v1:
+// prefix are returned unchanged. A nil keyring can only read plaintext.
+func (k *Keyring) Decrypt(value string) (string, error) {
+    if !IsEncrypted(value) {
+        return value, nil
+    }
+    if k == nil {
+        return "", ErrNoKeyring
+    }
+
+    k.mu.RLock()
+    id, dataKey, data, err := k.unwrap(value)
+    k.mu.RUnlock()
+    if errors.Is(err, ErrUnknownKey) {
+        // The key file may have been rotated since it was loaded
+        if reloaded, rerr := k.reload(); rerr != nil {
+            return "", fmt.Errorf("%w (reloading key file: %v)", err, rerr)
+        } else if reloaded {
+            k.mu.RLock()
+            id, dataKey, data, err = k.unwrap(value)
+            k.mu.RUnlock()
+        }
+    }
+    if err != nil {
+        return "", err
+    }
+
+    plaintext, err := open(dataKey, data, nil)
+    if err != nil {
+        return "", fmt.Errorf("failed to decrypt value (key %s): %w", id, err)
+    }
+    return string(plaintext), nil
+}
+
+// Rewrap re-encrypts the data key of a value with the active key without
+// touching the data. Plaintext values are encrypted. It reports whether
+// the value changed.
+func (k *Keyring) Rewrap(value string) (string, bool, error) {
+    if k == nil {
+        return "", false, ErrNoKeyring
+    }
+
+    k.mu.RLock()
+    defer k.mu.RUnlock()
+
+    if !IsEncrypted(value) {
+        enc, err := k.encrypt(value)
+        return enc, err == nil, err
+    }
+
+    id, dataKey, data, err := k.unwrap(value)
+    if err != nil {
+        return "", false, err
+    }
+    if id == k.Active {
+        return value, false, nil
+    }
+
+    wrapped, err := seal(k.Keys[k.Active], dataKey, []byte(k.Active))
+    if err != nil {
+        return "", false, err
+    }
+    return Prefix + k.Active + ":" + encode(wrapped) + ":" + encode(data), true, nil
+}
+
+// unwrap splits a value and decrypts its data key. The caller holds mu.
+func (k *Keyring) unwrap(value string) (string, []byte, []byte, error) {
+    parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+    if len(parts) != 3 {
+        return "", nil, nil, fmt.Errorf("malformed encrypted value")
+    }
+    id := parts[0]
+
+    key, ok := k.Keys[id]
+    if !ok {
+        return "", nil, nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+    }
+
+    wrapped, err := decode(parts[1])
+    if err != nil {
+        return "", nil, nil, fmt.Errorf("malformed encrypted value: %w", err)
+    }
+    data, err := decode(parts[2])
+    if err != nil {
+        return "", nil, nil, fmt.Errorf("malformed encrypted value: %w", err)
+    }
+
+    dataKey, err := open(key, wrapped, []byte(id))
+    if err != nil {
+        return "", nil, nil, fmt.Errorf("failed to unwrap data key (key %s): %w", id, err)
+    }
+    return id, dataKey, data, nil
+}
+
+// seal encrypts with AES-256-GCM and prepends the nonce
+func seal(key, plaintext, additional []byte) ([]byte, error) {
+    gcm, err := newGCM(key)
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, gcm.NonceSize())
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+    return gcm.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+// open reverses seal
+func open(key, sealed, additional []byte) ([]byte, error) {
+    gcm, err := newGCM(key)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(sealed) < gcm.NonceSize() {
+        return nil, fmt.Errorf("ciphertext too short")
+    }
+    nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+    return gcm.Open(nil, nonce, ciphertext, additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+func encode(b []byte) string {
+    return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+    return base64.RawStdEncoding.DecodeString(s)
+}
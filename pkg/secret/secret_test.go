@@ -0,0 +1,277 @@
+package secret
+
+import (
+    "encoding/json"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func newKeyring(t *testing.T) *Keyring {
+    k, err := NewKeyring()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return k
+}
+
+// save writes k to a temp key file and loads it back
+func save(t *testing.T, k *Keyring) (*Keyring, string) {
+    path := filepath.Join(t.TempDir(), "keys.json")
+    if err := k.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := Load(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return loaded, path
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+    k := newKeyring(t)
+
+    enc, err := k.Encrypt("s3cret:with:colons")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !IsEncrypted(enc) || strings.Contains(enc, "s3cret") {
+        t.Fatalf("Encrypt() = %q, want an enc:v1: value without the plaintext", enc)
+    }
+    if id, ok := KeyID(enc); !ok || id != k.Active {
+        t.Errorf("KeyID() = %q, %v, want %q", id, ok, k.Active)
+    }
+
+    again, err := k.Encrypt("s3cret:with:colons")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if again == enc {
+        t.Error("Encrypt() returned the same value twice, want a fresh data key and nonce")
+    }
+
+    got, err := k.Decrypt(enc)
+    if err != nil {
+        t.Fatalf("Decrypt() error = %v", err)
+    }
+    if got != "s3cret:with:colons" {
+        t.Errorf("Decrypt() = %q", got)
+    }
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+    for _, k := range []*Keyring{newKeyring(t), nil} {
+        got, err := k.Decrypt("plain-password")
+        if err != nil || got != "plain-password" {
+            t.Errorf("Decrypt() = %q, %v, want the plaintext unchanged", got, err)
+        }
+    }
+}
+
+func TestNilKeyring(t *testing.T) {
+    enc, err := newKeyring(t).Encrypt("s3cret")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var k *Keyring
+    if _, err := k.Decrypt(enc); !errors.Is(err, ErrNoKeyring) {
+        t.Errorf("Decrypt() error = %v, want ErrNoKeyring", err)
+    }
+    if _, err := k.Encrypt("s3cret"); !errors.Is(err, ErrNoKeyring) {
+        t.Errorf("Encrypt() error = %v, want ErrNoKeyring", err)
+    }
+    if _, _, err := k.Rewrap(enc); !errors.Is(err, ErrNoKeyring) {
+        t.Errorf("Rewrap() error = %v, want ErrNoKeyring", err)
+    }
+}
+
+func TestRewrapAfterRotate(t *testing.T) {
+    k := newKeyring(t)
+    old := k.Active
+
+    enc, err := k.Encrypt("s3cret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, changed, err := k.Rewrap(enc); err != nil || changed {
+        t.Errorf("Rewrap() with the active key changed = %v, err = %v, want unchanged", changed, err)
+    }
+
+    active, err := k.Rotate()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if active == old {
+        t.Fatalf("Rotate() kept key %s active", old)
+    }
+
+    rewrapped, changed, err := k.Rewrap(enc)
+    if err != nil || !changed {
+        t.Fatalf("Rewrap() changed = %v, err = %v", changed, err)
+    }
+    if id, _ := KeyID(rewrapped); id != active {
+        t.Errorf("rewrapped key ID = %s, want %s", id, active)
+    }
+
+    if removed := k.Prune(); len(removed) != 1 || removed[0] != old {
+        t.Errorf("Prune() = %v, want [%s]", removed, old)
+    }
+    got, err := k.Decrypt(rewrapped)
+    if err != nil || got != "s3cret" {
+        t.Errorf("Decrypt() after prune = %q, %v", got, err)
+    }
+    if _, err := k.Decrypt(enc); !errors.Is(err, ErrUnknownKey) {
+        t.Errorf("Decrypt() of a value under a pruned key error = %v, want ErrUnknownKey", err)
+    }
+}
+
+func TestRewrapPlaintext(t *testing.T) {
+    k := newKeyring(t)
+
+    enc, changed, err := k.Rewrap("plain-password")
+    if err != nil || !changed || !IsEncrypted(enc) {
+        t.Fatalf("Rewrap() = %q, %v, %v, want an encrypted value", enc, changed, err)
+    }
+    if got, err := k.Decrypt(enc); err != nil || got != "plain-password" {
+        t.Errorf("Decrypt() = %q, %v", got, err)
+    }
+}
+
+func TestDecryptReloadsKeyFile(t *testing.T) {
+    running, path := save(t, newKeyring(t))
+
+    // Another process rotates the key file and encrypts with the new key
+    rotated, err := Load(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := rotated.Rotate(); err != nil {
+        t.Fatal(err)
+    }
+    if err := rotated.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    later := time.Now().Add(time.Minute)
+    if err := os.Chtimes(path, later, later); err != nil {
+        t.Fatal(err)
+    }
+    enc, err := rotated.Encrypt("s3cret")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := running.Decrypt(enc)
+    if err != nil {
+        t.Fatalf("Decrypt() error = %v, want the key file reloaded", err)
+    }
+    if got != "s3cret" {
+        t.Errorf("Decrypt() = %q", got)
+    }
+    if running.Active != rotated.Active {
+        t.Errorf("active key after reload = %s, want %s", running.Active, rotated.Active)
+    }
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+    running, _ := save(t, newKeyring(t))
+
+    enc, err := newKeyring(t).Encrypt("s3cret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Same ID, different key: the wrapped data key cannot be opened
+    if _, err := running.Decrypt(enc); err == nil {
+        t.Error("Decrypt() with a different k1 succeeded")
+    }
+
+    enc = Prefix + "k9" + strings.TrimPrefix(enc, Prefix+"k1")
+    if _, err := running.Decrypt(enc); !errors.Is(err, ErrUnknownKey) {
+        t.Errorf("Decrypt() error = %v, want ErrUnknownKey", err)
+    }
+}
+
+func TestDecryptMalformed(t *testing.T) {
+    k := newKeyring(t)
+    enc, err := k.Encrypt("s3cret")
+    if err != nil {
+        t.Fatal(err)
+    }
+    parts := strings.Split(strings.TrimPrefix(enc, Prefix), ":")
+
+    data, err := decode(parts[2])
+    if err != nil {
+        t.Fatal(err)
+    }
+    data[len(data)-1] ^= 1
+    tampered := Prefix + parts[0] + ":" + parts[1] + ":" + encode(data)
+
+    wrapped, err := decode(parts[1])
+    if err != nil {
+        t.Fatal(err)
+    }
+    wrapped[0] ^= 1
+    tamperedKey := Prefix + parts[0] + ":" + encode(wrapped) + ":" + parts[2]
+
+    tests := []struct {
+        name  string
+        value string
+    }{
+        {"too few parts", Prefix + parts[0] + ":" + parts[1]},
+        {"too many parts", enc + ":extra"},
+        {"bad wrapped key base64", Prefix + parts[0] + ":not*base64:" + parts[2]},
+        {"bad data base64", Prefix + parts[0] + ":" + parts[1] + ":not*base64"},
+        {"short data", Prefix + parts[0] + ":" + parts[1] + ":" + encode([]byte("x"))},
+        {"tampered data", tampered},
+        {"tampered data key", tamperedKey},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got, err := k.Decrypt(tt.value); err == nil {
+                t.Errorf("Decrypt() = %q, want an error", got)
+            }
+        })
+    }
+}
+
+func TestLoadRejectsBadKeyFile(t *testing.T) {
+    good := make([]byte, keySize)
+
+    tests := []struct {
+        name   string
+        active string
+        keys   map[string][]byte
+        want   string
+    }{
+        {"missing active key", "k2", map[string][]byte{"k1": good}, "active key"},
+        {"no keys", "k1", nil, "active key"},
+        {"short key", "k1", map[string][]byte{"k1": good[:16]}, "16 bytes"},
+        {"short old key", "k2", map[string][]byte{"k1": good[:31], "k2": good}, "31 bytes"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            data, err := json.Marshal(&Keyring{Active: tt.active, Keys: tt.keys})
+            if err != nil {
+                t.Fatal(err)
+            }
+            path := filepath.Join(t.TempDir(), "keys.json")
+            if err := os.WriteFile(path, data, 0600); err != nil {
+                t.Fatal(err)
+            }
+
+            _, err = Load(path)
+            if err == nil || !strings.Contains(err.Error(), tt.want) {
+                t.Errorf("Load() error = %v, want one mentioning %q", err, tt.want)
+            }
+        })
+    }
+
+    if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+        t.Error("Load() of a missing file succeeded")
+    }
+}
@@ -0,0 +1,483 @@
+package session
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/secret"
+    "health-check-system/pkg/userpool"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Hop identifies one leg of the multi-hop SSH chain
+type Hop string
+
+const (
+    HopProxy Hop = "mito_proxy"
+    HopNiam  Hop = "niam"
+    HopNode  Hop = "node"
+)
+
+// DialFunc opens the TCP connection to the first hop (the Mito proxy)
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Config holds SSH session settings
+type Config struct {
+    Timeout           time.Duration
+    MaxRetries        int
+    RetryDelay        time.Duration
+    KeepaliveInterval time.Duration
+    ProxyPassword     string
+    NodePort          int
+
+    // Secrets decrypts the proxy and NIAM passwords when a session is
+    // opened. Without it only plaintext passwords can be used.
+    Secrets *secret.Keyring
+
+    // HostKeyCallback verifies host keys on every hop, see HostKeys.
+    // Without one every host key is rejected.
+    HostKeyCallback ssh.HostKeyCallback
+
+    // Dial replaces the TCP dialer used for the first hop, so the chain
+    // can be pointed at in-process servers
+    Dial DialFunc
+}
+
+// ErrAuthFailed is wrapped by handshake errors caused by rejected
+// credentials. These are not retried, to avoid locking accounts.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// IsAuthFailure reports whether err was caused by rejected credentials
+func IsAuthFailure(err error) bool {
+    return errors.Is(err, ErrAuthFailed)
+}
+
+// ErrNoHostKeys is returned by the handshake when no host key callback
+// is configured
+var ErrNoHostKeys = errors.New("no host keys configured")
+
+// HostKeys returns a callback that checks host keys against a known_hosts
+// file. insecure accepts any host key instead, for lab setups only.
+func HostKeys(knownHostsFile string, insecure bool) (ssh.HostKeyCallback, error) {
+    if insecure {
+        return ssh.InsecureIgnoreHostKey(), nil
+    }
+    if knownHostsFile == "" {
+        return nil, errors.New("known_hosts file not set")
+    }
+    callback, err := knownhosts.New(knownHostsFile)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+    }
+    return callback, nil
+}
+
+// ErrDecrypt is wrapped by errors decrypting the proxy or NIAM password.
+// These are not retried, as another attempt fails the same way.
+var ErrDecrypt = errors.New("cannot decrypt password")
+
+// HopError describes a failure on one hop of the chain
+type HopError struct {
+    Hop  Hop
+    Addr string
+    Err  error
+}
+
+func (e *HopError) Error() string {
+    return fmt.Sprintf("%s %s: %v", e.Hop, e.Addr, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+    return e.Err
+}
+
+// Manager opens SSH sessions: Mito Proxy → NIAM Proxy → Target Node
+type Manager struct {
+    cfg Config
+}
+
+// NewManager creates a new session manager
+func NewManager(cfg Config) *Manager {
+    if cfg.Timeout <= 0 {
+        cfg.Timeout = 30 * time.Second
+    }
+    if cfg.MaxRetries <= 0 {
+        cfg.MaxRetries = 1
+    }
+    if cfg.RetryDelay <= 0 {
+        cfg.RetryDelay = 2 * time.Second
+    }
+    if cfg.NodePort == 0 {
+        cfg.NodePort = 22
+    }
+    if cfg.HostKeyCallback == nil {
+        cfg.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error {
+            return ErrNoHostKeys
+        }
+    }
+    if cfg.Dial == nil {
+        dialer := &net.Dialer{Timeout: cfg.Timeout}
+        cfg.Dial = dialer.DialContext
+    }
+
+    return &Manager{
+        cfg: cfg,
+    }
+}
+
+// Open connects to the node through the proxy and the user's NIAM server.
+// The whole chain is retried up to MaxRetries times. Callers that retry
+// themselves, e.g. through proxy failover, use OpenOnce instead.
+func (m *Manager) Open(ctx context.Context, px *proxy.Proxy, user *userpool.User, node *inventory.Node) (*Session, error) {
+    var lastErr error
+
+    for attempt := 1; attempt <= m.cfg.MaxRetries; attempt++ {
+        if attempt > 1 {
+            select {
+            case <-ctx.Done():
+                return nil, ctx.Err()
+            case <-time.After(m.cfg.RetryDelay):
+            }
+        }
+
+        sess, err := m.open(ctx, px, user, node)
+        if err == nil {
+            return sess, nil
+        }
+        lastErr = err
+
+        if ctx.Err() != nil || IsAuthFailure(err) || errors.Is(err, ErrDecrypt) {
+            return nil, fmt.Errorf("failed to open session to %s: %w", node.NeID, err)
+        }
+    }
+
+    return nil, fmt.Errorf("failed to open session to %s after %d attempts: %w",
+        node.NeID, m.cfg.MaxRetries, lastErr)
+}
+
+// OpenOnce connects to the node like Open but makes a single attempt
+func (m *Manager) OpenOnce(ctx context.Context, px *proxy.Proxy, user *userpool.User, node *inventory.Node) (*Session, error) {
+    sess, err := m.open(ctx, px, user, node)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open session to %s: %w", node.NeID, err)
+    }
+    return sess, nil
+}
+
+type hop struct {
+    hop      Hop
+    addr     string
+    user     string
+    password string
+}
+
+// open builds the chain once
+func (m *Manager) open(ctx context.Context, px *proxy.Proxy, user *userpool.User, node *inventory.Node) (*Session, error) {
+    proxyPassword, err := m.cfg.Secrets.Decrypt(m.cfg.ProxyPassword)
+    if err != nil {
+        return nil, fmt.Errorf("%w of proxy: %w", ErrDecrypt, err)
+    }
+    userPassword, err := m.cfg.Secrets.Decrypt(user.Password)
+    if err != nil {
+        return nil, fmt.Errorf("%w of %s: %w", ErrDecrypt, user.Username, err)
+    }
+
+    hops := []hop{
+        {HopProxy, net.JoinHostPort(px.IP, strconv.Itoa(px.Port)), px.User, proxyPassword},
+        {HopNiam, net.JoinHostPort(user.NiamIP, user.NiamPort), user.Username, userPassword},
+        {HopNode, net.JoinHostPort(node.IPAddress, strconv.Itoa(m.cfg.NodePort)), user.Username, userPassword},
+    }
+
+    var clients []*ssh.Client
+    closeAll := func() {
+        for i := len(clients) - 1; i >= 0; i-- {
+            clients[i].Close()
+        }
+    }
+
+    for _, h := range hops {
+        client, err := m.dialHop(ctx, clients, h)
+        if err != nil {
+            closeAll()
+            return nil, &HopError{Hop: h.hop, Addr: h.addr, Err: err}
+        }
+        clients = append(clients, client)
+    }
+
+    sess := &Session{
+        NeID:    node.NeID,
+        clients: clients,
+        done:    make(chan struct{}),
+    }
+    if m.cfg.KeepaliveInterval > 0 {
+        go sess.keepalive(m.cfg.KeepaliveInterval)
+    }
+
+    return sess, nil
+}
+
+// dialHop opens a connection to h, tunnelled through the last client in
+// the chain when there is one, and performs the SSH handshake
+func (m *Manager) dialHop(ctx context.Context, chain []*ssh.Client, h hop) (*ssh.Client, error) {
+    ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+    defer cancel()
+
+    var conn net.Conn
+    var err error
+    if len(chain) == 0 {
+        conn, err = m.cfg.Dial(ctx, "tcp", h.addr)
+    } else {
+        conn, err = chain[len(chain)-1].DialContext(ctx, "tcp", h.addr)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("dial failed: %w", err)
+    }
+
+    clientConfig := &ssh.ClientConfig{
+        User: h.user,
+        Auth: []ssh.AuthMethod{
+            ssh.Password(h.password),
+            ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+                answers := make([]string, len(questions))
+                for i := range answers {
+                    answers[i] = h.password
+                }
+                return answers, nil
+            }),
+        },
+        HostKeyCallback: m.cfg.HostKeyCallback,
+        Timeout:         m.cfg.Timeout,
+    }
+
+    // Tunnelled connections do not support deadlines, so the handshake is
+    // bounded by closing the connection when the context expires
+    type result struct {
+        client *ssh.Client
+        err    error
+    }
+    resultCh := make(chan result, 1)
+    go func() {
+        c, chans, reqs, err := ssh.NewClientConn(conn, h.addr, clientConfig)
+        if err != nil {
+            resultCh <- result{err: err}
+            return
+        }
+        resultCh <- result{client: ssh.NewClient(c, chans, reqs)}
+    }()
+
+    select {
+    case r := <-resultCh:
+        if r.err != nil {
+            conn.Close()
+            if isAuthError(r.err) {
+                return nil, fmt.Errorf("handshake failed: %w: %w", ErrAuthFailed, r.err)
+            }
+            return nil, fmt.Errorf("handshake failed: %w", r.err)
+        }
+        return r.client, nil
+    case <-ctx.Done():
+        conn.Close()
+        return nil, fmt.Errorf("handshake failed: %w", ctx.Err())
+    }
+}
+
+// isAuthError reports whether an SSH handshake error means the server
+// rejected every authentication method
+func isAuthError(err error) bool {
+    msg := err.Error()
+    return strings.Contains(msg, "unable to authenticate") ||
+        strings.Contains(msg, "no supported methods remain")
+}
+
+// Session is an open connection to a target node
+type Session struct {
+    NeID string
+
+    clients   []*ssh.Client
+    done      chan struct{}
+    closeOnce sync.Once
+}
+
+// keepalive pings every hop until the session is closed
+func (s *Session) keepalive(interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-ticker.C:
+            for _, c := range s.clients {
+                if _, _, err := c.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+                    s.Close()
+                    return
+                }
+            }
+        }
+    }
+}
+
+// node returns the client connected to the target node
+func (s *Session) node() *ssh.Client {
+    return s.clients[len(s.clients)-1]
+}
+
+// Command is a command started on the node
+type Command struct {
+    Cmd string
+
+    session *ssh.Session
+    output  *bytes.Buffer
+    done    chan error
+}
+
+// Start runs cmd on the node in its own channel without waiting for it
+func (s *Session) Start(cmd string) (*Command, error) {
+    sess, err := s.node().NewSession()
+    if err != nil {
+        return nil, fmt.Errorf("failed to open channel: %w", err)
+    }
+
+    output := &bytes.Buffer{}
+    w := &syncWriter{w: output}
+    sess.Stdout = w
+    sess.Stderr = w
+
+    if err := sess.Start(cmd); err != nil {
+        sess.Close()
+        return nil, fmt.Errorf("failed to start %q: %w", cmd, err)
+    }
+
+    c := &Command{
+        Cmd:     cmd,
+        session: sess,
+        output:  output,
+        done:    make(chan error, 1),
+    }
+    go func() {
+        c.done <- sess.Wait()
+    }()
+
+    return c, nil
+}
+
+// Wait waits for the command to finish and returns its combined output
+func (c *Command) Wait(ctx context.Context) (string, error) {
+    defer c.session.Close()
+
+    select {
+    case err := <-c.done:
+        if err != nil {
+            return c.output.String(), fmt.Errorf("command %q failed: %w", c.Cmd, err)
+        }
+        return c.output.String(), nil
+    case <-ctx.Done():
+        return "", fmt.Errorf("command %q: %w", c.Cmd, ctx.Err())
+    }
+}
+
+// Run runs cmd on the node and returns its combined output
+func (s *Session) Run(ctx context.Context, cmd string) (string, error) {
+    c, err := s.Start(cmd)
+    if err != nil {
+        return "", err
+    }
+    return c.Wait(ctx)
+}
+
+// Shell is an interactive channel to the node
+type Shell struct {
+    Stdin  io.WriteCloser
+    Stdout io.Reader
+
+    session *ssh.Session
+}
+
+// Shell opens an interactive shell with a PTY on the node
+func (s *Session) Shell() (*Shell, error) {
+    sess, err := s.node().NewSession()
+    if err != nil {
+        return nil, fmt.Errorf("failed to open channel: %w", err)
+    }
+
+    modes := ssh.TerminalModes{
+        ssh.ECHO:          0,
+        ssh.TTY_OP_ISPEED: 14400,
+        ssh.TTY_OP_OSPEED: 14400,
+    }
+    if err := sess.RequestPty("vt100", 0, 512, modes); err != nil {
+        sess.Close()
+        return nil, fmt.Errorf("failed to request pty: %w", err)
+    }
+
+    stdin, err := sess.StdinPipe()
+    if err != nil {
+        sess.Close()
+        return nil, err
+    }
+    stdout, err := sess.StdoutPipe()
+    if err != nil {
+        sess.Close()
+        return nil, err
+    }
+
+    if err := sess.Shell(); err != nil {
+        sess.Close()
+        return nil, fmt.Errorf("failed to start shell: %w", err)
+    }
+
+    return &Shell{
+        Stdin:   stdin,
+        Stdout:  stdout,
+        session: sess,
+    }, nil
+}
+
+// Wait waits for the remote shell to exit
+func (sh *Shell) Wait() error {
+    return sh.session.Wait()
+}
+
+// Close closes the shell channel
+func (sh *Shell) Close() error {
+    return sh.session.Close()
+}
+
+// Close closes every hop, innermost first
+func (s *Session) Close() error {
+    var firstErr error
+    s.closeOnce.Do(func() {
+        close(s.done)
+        for i := len(s.clients) - 1; i >= 0; i-- {
+            if err := s.clients[i].Close(); err != nil && firstErr == nil {
+                firstErr = err
+            }
+        }
+    })
+    return firstErr
+}
+
+// syncWriter serialises writes from the stdout and stderr copiers
+type syncWriter struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+    sw.mu.Lock()
+    defer sw.mu.Unlock()
+    return sw.w.Write(p)
+}
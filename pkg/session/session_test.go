@@ -0,0 +1,378 @@
+package session
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "health-check-system/pkg/inventory"
+    "health-check-system/pkg/proxy"
+    "health-check-system/pkg/userpool"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeNet is a set of in-process SSH servers addressed like real hosts.
+// Connections to and between them are loopback TCP pairs; net.Pipe is
+// unbuffered and deadlocks the SSH version exchange.
+type fakeNet struct {
+    servers map[string]*fakeServer
+}
+
+// fakeServer accepts one user, forwards direct-tcpip channels to other
+// servers of its fakeNet and answers exec requests
+type fakeServer struct {
+    net    *fakeNet
+    key    ssh.Signer
+    config *ssh.ServerConfig
+}
+
+func (n *fakeNet) add(t *testing.T, addr, user, password string) *fakeServer {
+    _, priv, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    key, err := ssh.NewSignerFromKey(priv)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    config := &ssh.ServerConfig{
+        PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+            if c.User() == user && string(pass) == password {
+                return nil, nil
+            }
+            return nil, fmt.Errorf("password rejected for %s", c.User())
+        },
+    }
+    config.AddHostKey(key)
+
+    s := &fakeServer{net: n, key: key, config: config}
+    n.servers[addr] = s
+    return s
+}
+
+// pipe returns both ends of a loopback TCP connection
+func pipe() (net.Conn, net.Conn, error) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        return nil, nil, err
+    }
+    defer ln.Close()
+
+    accepted := make(chan net.Conn, 1)
+    go func() {
+        conn, _ := ln.Accept()
+        accepted <- conn
+    }()
+
+    client, err := net.Dial("tcp", ln.Addr().String())
+    if err != nil {
+        return nil, nil, err
+    }
+    server := <-accepted
+    if server == nil {
+        client.Close()
+        return nil, nil, errors.New("accept failed")
+    }
+    return client, server, nil
+}
+
+// tcpConn gives a pipe the remote address of the host it stands for, as
+// known_hosts checks need one
+type tcpConn struct {
+    net.Conn
+    remote net.Addr
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr { return c.remote }
+
+// dial is the Config.Dial of the first hop
+func (n *fakeNet) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+    s, ok := n.servers[addr]
+    if !ok {
+        return nil, fmt.Errorf("dial %s: connection refused", addr)
+    }
+    remote, err := net.ResolveTCPAddr("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+
+    client, server, err := pipe()
+    if err != nil {
+        return nil, err
+    }
+    go s.serve(server)
+    return &tcpConn{Conn: client, remote: remote}, nil
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+    sc, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+    if err != nil {
+        conn.Close()
+        return
+    }
+    defer sc.Close()
+    go ssh.DiscardRequests(reqs)
+
+    for nc := range chans {
+        switch nc.ChannelType() {
+        case "direct-tcpip":
+            go s.forward(nc)
+        case "session":
+            go s.session(nc)
+        default:
+            nc.Reject(ssh.UnknownChannelType, "unsupported channel")
+        }
+    }
+}
+
+// forward connects a direct-tcpip channel to the addressed server
+func (s *fakeServer) forward(nc ssh.NewChannel) {
+    var req struct {
+        Host       string
+        Port       uint32
+        OriginHost string
+        OriginPort uint32
+    }
+    if err := ssh.Unmarshal(nc.ExtraData(), &req); err != nil {
+        nc.Reject(ssh.ConnectionFailed, err.Error())
+        return
+    }
+    target, ok := s.net.servers[net.JoinHostPort(req.Host, strconv.Itoa(int(req.Port)))]
+    if !ok {
+        nc.Reject(ssh.ConnectionFailed, "connection refused")
+        return
+    }
+
+    ch, reqs, err := nc.Accept()
+    if err != nil {
+        return
+    }
+    go ssh.DiscardRequests(reqs)
+
+    local, remote, err := pipe()
+    if err != nil {
+        ch.Close()
+        return
+    }
+    go target.serve(remote)
+    go func() {
+        io.Copy(ch, local)
+        ch.Close()
+    }()
+    io.Copy(local, ch)
+    local.Close()
+}
+
+// session answers exec requests with the command that was run
+func (s *fakeServer) session(nc ssh.NewChannel) {
+    ch, reqs, err := nc.Accept()
+    if err != nil {
+        return
+    }
+    defer ch.Close()
+
+    for req := range reqs {
+        if req.Type != "exec" {
+            req.Reply(false, nil)
+            continue
+        }
+        var exec struct{ Command string }
+        ssh.Unmarshal(req.Payload, &exec)
+        req.Reply(true, nil)
+
+        fmt.Fprintf(ch, "ran %s\n", exec.Command)
+        ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+        return
+    }
+}
+
+const (
+    proxyAddr = "10.0.0.1:22"
+    niamAddr  = "10.0.0.2:2222"
+    nodeAddr  = "10.0.0.3:22"
+)
+
+// fixture is a proxy → NIAM → node chain and the arguments of Open
+type fixture struct {
+    net  *fakeNet
+    cfg  Config
+    px   *proxy.Proxy
+    user *userpool.User
+    node *inventory.Node
+}
+
+func newFixture(t *testing.T) *fixture {
+    n := &fakeNet{servers: make(map[string]*fakeServer)}
+    n.add(t, proxyAddr, "mito", "proxy-pass")
+    n.add(t, niamAddr, "niam01", "niam-pass")
+    n.add(t, nodeAddr, "niam01", "niam-pass")
+
+    return &fixture{
+        net: n,
+        cfg: Config{
+            Timeout:         5 * time.Second,
+            ProxyPassword:   "proxy-pass",
+            HostKeyCallback: knownHostsFor(t, n, proxyAddr, niamAddr, nodeAddr),
+            Dial:            n.dial,
+        },
+        px:   &proxy.Proxy{Name: "mito-1", IP: "10.0.0.1", Port: 22, User: "mito"},
+        user: &userpool.User{Username: "niam01", Password: "niam-pass", NiamIP: "10.0.0.2", NiamPort: "2222"},
+        node: &inventory.Node{NeID: "NE1", IPAddress: "10.0.0.3"},
+    }
+}
+
+// knownHostsFor writes a known_hosts file with the keys of the given
+// servers and loads it through HostKeys
+func knownHostsFor(t *testing.T, n *fakeNet, addrs ...string) ssh.HostKeyCallback {
+    var lines []string
+    for _, addr := range addrs {
+        lines = append(lines, knownhosts.Line([]string{addr}, n.servers[addr].key.PublicKey()))
+    }
+
+    path := filepath.Join(t.TempDir(), "known_hosts")
+    if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    callback, err := HostKeys(path, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return callback
+}
+
+func TestOpen(t *testing.T) {
+    tests := []struct {
+        name     string
+        modify   func(t *testing.T, f *fixture)
+        wantHop  Hop
+        wantAuth bool
+        wantErr  func(error) bool
+    }{
+        {
+            name: "all hops",
+        },
+        {
+            name:    "proxy unreachable",
+            modify:  func(t *testing.T, f *fixture) { delete(f.net.servers, proxyAddr) },
+            wantHop: HopProxy,
+        },
+        {
+            name:     "proxy password rejected",
+            modify:   func(t *testing.T, f *fixture) { f.cfg.ProxyPassword = "wrong" },
+            wantHop:  HopProxy,
+            wantAuth: true,
+        },
+        {
+            name:     "NIAM password rejected",
+            modify:   func(t *testing.T, f *fixture) { f.user.Password = "wrong" },
+            wantHop:  HopNiam,
+            wantAuth: true,
+        },
+        {
+            name:    "node unreachable",
+            modify:  func(t *testing.T, f *fixture) { delete(f.net.servers, nodeAddr) },
+            wantHop: HopNode,
+        },
+        {
+            name: "node host key unknown",
+            modify: func(t *testing.T, f *fixture) {
+                f.cfg.HostKeyCallback = knownHostsFor(t, f.net, proxyAddr, niamAddr)
+            },
+            wantHop: HopNode,
+            wantErr: func(err error) bool {
+                var keyErr *knownhosts.KeyError
+                return errors.As(err, &keyErr)
+            },
+        },
+        {
+            name:    "no host keys configured",
+            modify:  func(t *testing.T, f *fixture) { f.cfg.HostKeyCallback = nil },
+            wantHop: HopProxy,
+            wantErr: func(err error) bool { return errors.Is(err, ErrNoHostKeys) },
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newFixture(t)
+            if tt.modify != nil {
+                tt.modify(t, f)
+            }
+            m := NewManager(f.cfg)
+
+            ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+            defer cancel()
+            sess, err := m.OpenOnce(ctx, f.px, f.user, f.node)
+
+            if tt.wantHop == "" {
+                if err != nil {
+                    t.Fatalf("OpenOnce() error = %v", err)
+                }
+                defer sess.Close()
+
+                out, err := sess.Run(ctx, "show version")
+                if err != nil {
+                    t.Fatalf("Run() error = %v", err)
+                }
+                if out != "ran show version\n" {
+                    t.Errorf("Run() = %q", out)
+                }
+                return
+            }
+
+            if err == nil {
+                sess.Close()
+                t.Fatalf("OpenOnce() succeeded, want %s failure", tt.wantHop)
+            }
+            var hopErr *HopError
+            if !errors.As(err, &hopErr) {
+                t.Fatalf("OpenOnce() error = %v, want a HopError", err)
+            }
+            if hopErr.Hop != tt.wantHop {
+                t.Errorf("failed hop = %s, want %s (%v)", hopErr.Hop, tt.wantHop, err)
+            }
+            if got := IsAuthFailure(err); got != tt.wantAuth {
+                t.Errorf("IsAuthFailure() = %v, want %v (%v)", got, tt.wantAuth, err)
+            }
+            if tt.wantErr != nil && !tt.wantErr(err) {
+                t.Errorf("unexpected error %v", err)
+            }
+        })
+    }
+}
+
+func TestOpenDoesNotRetryAuthFailures(t *testing.T) {
+    f := newFixture(t)
+    f.user.Password = "wrong"
+    f.cfg.MaxRetries = 3
+    f.cfg.RetryDelay = time.Hour
+
+    done := make(chan error, 1)
+    go func() {
+        _, err := NewManager(f.cfg).Open(context.Background(), f.px, f.user, f.node)
+        done <- err
+    }()
+
+    select {
+    case err := <-done:
+        if !IsAuthFailure(err) {
+            t.Errorf("Open() error = %v, want an auth failure", err)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("Open() retried a rejected password")
+    }
+}
@@ -0,0 +1,114 @@
+package status
+
+import (
+    "database/sql"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// ErrNotActive is returned when a cancel targets a node or session with
+// no check in progress
+var ErrNotActive = errors.New("no active check")
+
+// CancelRequest asks the process running a check to cancel it
+type CancelRequest struct {
+    SessionID   string
+    NeID        string
+    Reason      string
+    RequestedBy string
+    RequestedAt time.Time
+}
+
+// RequestCancel records a request to cancel the active check of a node.
+// target is either the node's neId or the check's session ID. The process
+// running the check picks the request up with GetCancelRequests.
+func (m *Manager) RequestCancel(target, reason, requestedBy string) (*CancelRequest, error) {
+    req := &CancelRequest{Reason: reason, RequestedBy: requestedBy}
+
+    var current Status
+    err := m.db.QueryRow(`
+        SELECT neId, COALESCE(current_session_id, ''), current_status
+        FROM hc_node_status
+        WHERE neId = ? OR current_session_id = ?
+        LIMIT 1
+    `, target, target).Scan(&req.NeID, &req.SessionID, &current)
+    if err == sql.ErrNoRows {
+        return nil, fmt.Errorf("no node or session %s", target)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !current.Active() || req.SessionID == "" {
+        return nil, fmt.Errorf("%w for %s (status %s)", ErrNotActive, req.NeID, current)
+    }
+
+    _, err = m.db.Exec(`
+        INSERT INTO hc_cancel_requests (session_id, neId, reason, requested_by)
+        VALUES (?, ?, NULLIF(?, ''), ?)
+        ON DUPLICATE KEY UPDATE
+            reason = VALUES(reason),
+            requested_by = VALUES(requested_by),
+            requested_at = NOW()
+    `, req.SessionID, req.NeID, reason, requestedBy)
+    if err != nil {
+        return nil, fmt.Errorf("failed to request cancel of %s: %w", req.SessionID, err)
+    }
+
+    req.RequestedAt = time.Now()
+    return req, nil
+}
+
+// GetCancelRequests returns the pending cancel requests for sessionIDs
+func (m *Manager) GetCancelRequests(sessionIDs []string) ([]*CancelRequest, error) {
+    if len(sessionIDs) == 0 {
+        return nil, nil
+    }
+
+    args := make([]interface{}, 0, len(sessionIDs))
+    for _, id := range sessionIDs {
+        args = append(args, id)
+    }
+
+    rows, err := m.db.Query(`
+        SELECT session_id, neId, COALESCE(reason, ''), requested_by, requested_at
+        FROM hc_cancel_requests
+        WHERE session_id IN (?`+strings.Repeat(", ?", len(sessionIDs)-1)+`)
+    `, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var requests []*CancelRequest
+    for rows.Next() {
+        r := &CancelRequest{}
+        if err := rows.Scan(&r.SessionID, &r.NeID, &r.Reason, &r.RequestedBy, &r.RequestedAt); err != nil {
+            return nil, err
+        }
+        requests = append(requests, r)
+    }
+
+    return requests, rows.Err()
+}
+
+// ClearCancelRequest removes the cancel request of a finished session
+func (m *Manager) ClearCancelRequest(sessionID string) error {
+    _, err := m.db.Exec(`DELETE FROM hc_cancel_requests WHERE session_id = ?`, sessionID)
+    return err
+}
+
+// ExpireCancelRequests removes cancel requests older than maxAge, which
+// no running check can still pick up. It returns the number removed.
+func (m *Manager) ExpireCancelRequests(maxAge time.Duration) (int, error) {
+    res, err := m.db.Exec(`
+        DELETE FROM hc_cancel_requests
+        WHERE requested_at < NOW() - INTERVAL ? SECOND
+    `, int(maxAge.Seconds()))
+    if err != nil {
+        return 0, err
+    }
+    n, err := res.RowsAffected()
+    return int(n), err
+}
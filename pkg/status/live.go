@@ -0,0 +1,93 @@
+package status
+
+import (
+    "database/sql"
+    "time"
+)
+
+// LiveUpdate is a progress row of hc_live_updates
+type LiveUpdate struct {
+    ID        int64     `json:"id"`
+    SessionID string    `json:"session_id"`
+    NeID      string    `json:"neId"`
+    Timestamp time.Time `json:"timestamp"`
+    Status    string    `json:"status"`
+    Message   string    `json:"message"`
+    Progress  int       `json:"progress_percentage"`
+}
+
+// LiveFilter selects live updates by session or node. The zero value
+// matches every update.
+type LiveFilter struct {
+    SessionID string
+    NeID      string
+}
+
+// Match reports whether u passes the filter
+func (f LiveFilter) Match(u *LiveUpdate) bool {
+    return (f.SessionID == "" || f.SessionID == u.SessionID) &&
+        (f.NeID == "" || f.NeID == u.NeID)
+}
+
+// GetLiveUpdates returns the newest limit updates with an ID above
+// afterID that match f, oldest first
+func (m *Manager) GetLiveUpdates(f LiveFilter, afterID int64, limit int) ([]*LiveUpdate, error) {
+    rows, err := m.db.Query(`
+        SELECT id, session_id, neId, timestamp, COALESCE(status, ''),
+               COALESCE(message, ''), COALESCE(progress_percentage, 0)
+        FROM (
+            SELECT *
+            FROM hc_live_updates
+            WHERE id > ?
+              AND (? = '' OR session_id = ?)
+              AND (? = '' OR neId = ?)
+            ORDER BY id DESC
+            LIMIT ?
+        ) recent
+        ORDER BY id ASC
+    `, afterID, f.SessionID, f.SessionID, f.NeID, f.NeID, limit)
+    if err != nil {
+        return nil, err
+    }
+    return scanLiveUpdates(rows)
+}
+
+// TailLiveUpdates returns up to limit updates with an ID above afterID,
+// oldest first, for following the table
+func (m *Manager) TailLiveUpdates(afterID int64, limit int) ([]*LiveUpdate, error) {
+    rows, err := m.db.Query(`
+        SELECT id, session_id, neId, timestamp, COALESCE(status, ''),
+               COALESCE(message, ''), COALESCE(progress_percentage, 0)
+        FROM hc_live_updates
+        WHERE id > ?
+        ORDER BY id ASC
+        LIMIT ?
+    `, afterID, limit)
+    if err != nil {
+        return nil, err
+    }
+    return scanLiveUpdates(rows)
+}
+
+func scanLiveUpdates(rows *sql.Rows) ([]*LiveUpdate, error) {
+    defer rows.Close()
+
+    var updates []*LiveUpdate
+    for rows.Next() {
+        u := &LiveUpdate{}
+        err := rows.Scan(&u.ID, &u.SessionID, &u.NeID, &u.Timestamp, &u.Status, &u.Message, &u.Progress)
+        if err != nil {
+            return nil, err
+        }
+        updates = append(updates, u)
+    }
+
+    return updates, rows.Err()
+}
+
+// GetLastLiveUpdateID returns the ID of the newest live update, or 0
+func (m *Manager) GetLastLiveUpdateID() (int64, error) {
+    var id int64
+    err := m.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM hc_live_updates`).Scan(&id)
+    return id, err
+}
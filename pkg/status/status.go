@@ -2,6 +2,9 @@ package status
 
 import (
     "database/sql"
+    "errors"
+    "fmt"
+    "time"
 )
 
 // Status represents node status
@@ -13,11 +16,81 @@ const (
     StatusConnecting Status = "connecting"
     StatusRunning    Status = "running"
     StatusPolling    Status = "polling"
+    StatusCollecting Status = "collecting"
     StatusCompleted  Status = "completed"
     StatusFailed     Status = "failed"
     StatusTimeout    Status = "timeout"
+    StatusCancelled  Status = "cancelled"
 )
 
+// transitions lists the legal moves of the state machine. A check goes
+// queued → connecting → running → polling → collecting → completed and
+// can end in failed, timeout or cancelled from any active state. A check
+// that is retried goes back to queued. Active checks return to idle when
+// their process dies, and finished nodes are queued again when they are
+// due.
+var transitions = map[Status][]Status{
+    StatusIdle:       {StatusQueued},
+    StatusQueued:     {StatusConnecting, StatusFailed, StatusTimeout, StatusCancelled, StatusIdle},
+    StatusConnecting: {StatusRunning, StatusQueued, StatusFailed, StatusTimeout, StatusCancelled, StatusIdle},
+    StatusRunning:    {StatusPolling, StatusQueued, StatusFailed, StatusTimeout, StatusCancelled, StatusIdle},
+    StatusPolling:    {StatusCollecting, StatusQueued, StatusFailed, StatusTimeout, StatusCancelled, StatusIdle},
+    StatusCollecting: {StatusCompleted, StatusFailed, StatusTimeout, StatusCancelled, StatusIdle},
+    StatusCompleted:  {StatusQueued},
+    StatusFailed:     {StatusQueued},
+    StatusTimeout:    {StatusQueued},
+    StatusCancelled:  {StatusQueued},
+}
+
+// CanTransition reports whether a node may move from one status to another
+func CanTransition(from, to Status) bool {
+    for _, next := range transitions[from] {
+        if next == to {
+            return true
+        }
+    }
+    return false
+}
+
+// Active reports whether a check is in progress in this status
+func (s Status) Active() bool {
+    switch s {
+    case StatusQueued, StatusConnecting, StatusRunning, StatusPolling, StatusCollecting:
+        return true
+    }
+    return false
+}
+
+// Final reports whether a check has finished in this status
+func (s Status) Final() bool {
+    switch s {
+    case StatusCompleted, StatusFailed, StatusTimeout, StatusCancelled:
+        return true
+    }
+    return false
+}
+
+// TransitionError is returned when a status change is not allowed, or
+// when the node changed status or session since it was read
+type TransitionError struct {
+    NeID      string
+    From      Status
+    To        Status
+    SessionID string
+
+    // Conflict is set when the node is held by another session or was
+    // changed concurrently, rather than the move itself being illegal
+    Conflict bool
+}
+
+func (e *TransitionError) Error() string {
+    if e.Conflict {
+        return fmt.Sprintf("status of %s changed concurrently (now %s), cannot move to %s for session %s",
+            e.NeID, e.From, e.To, e.SessionID)
+    }
+    return fmt.Sprintf("illegal status transition for %s: %s → %s", e.NeID, e.From, e.To)
+}
+
 // Manager manages node status
 type Manager struct {
     db *sql.DB
@@ -30,33 +103,142 @@ func NewManager(db *sql.DB) *Manager {
     }
 }
 
-// UpdateStatus updates node status
+// UpdateStatus moves a node to status for a session. Queuing an idle or
+// finished node assigns the session and resets its retry count; moving an
+// active node requires it to be held by the session. Illegal or
+// conflicting moves return a *TransitionError.
 func (m *Manager) UpdateStatus(neID string, status Status, sessionID, username string) error {
-    _, err := m.db.Exec(`
-        UPDATE hc_node_status
-        SET current_status = ?,
+    return m.transition(neID, sessionID, status, `
             current_session_id = ?,
             current_username = ?,
             last_check_started = CASE WHEN ? = 'running' THEN NOW() ELSE last_check_started END,
-            updated_at = NOW()
-        WHERE neId = ?
-    `, status, sessionID, username, status, neID)
+            retry_count = CASE WHEN ? = 'queued' THEN 0 ELSE retry_count END,
+            updated_at = NOW()`,
+        sessionID, username, status, status)
+}
+
+// Retry puts an active check back in the queue before another attempt
+// and stores its retry count
+func (m *Manager) Retry(neID, sessionID string, retryCount int, errorMsg string) error {
+    err := m.transition(neID, sessionID, StatusQueued, `
+            current_username = NULL,
+            retry_count = ?,
+            error_message = ?,
+            updated_at = NOW()`,
+        retryCount, errorMsg)
 
+    // A check that failed before leaving the queue stays queued
+    var terr *TransitionError
+    if errors.As(err, &terr) && !terr.Conflict && terr.From == StatusQueued {
+        _, err = m.db.Exec(`
+            UPDATE hc_node_status
+            SET retry_count = ?,
+                error_message = ?,
+                updated_at = NOW()
+            WHERE neId = ? AND current_status = 'queued' AND current_session_id = ?
+        `, retryCount, errorMsg, neID, sessionID)
+    }
     return err
 }
 
+// transition moves a node to status `to` using optimistic concurrency:
+// the UPDATE only applies if the status (and session) read beforehand are
+// unchanged. set holds the other assignments and args their values. The
+// move is recorded in hc_status_transitions.
+func (m *Manager) transition(neID, sessionID string, to Status, set string, args ...interface{}) error {
+    tx, err := m.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var from Status
+    var holder string
+    err = tx.QueryRow(`
+        SELECT current_status, COALESCE(current_session_id, '')
+        FROM hc_node_status
+        WHERE neId = ?
+    `, neID).Scan(&from, &holder)
+    if err != nil {
+        return fmt.Errorf("failed to read status of %s: %w", neID, err)
+    }
+
+    terr := &TransitionError{NeID: neID, From: from, To: to, SessionID: sessionID}
+    if !CanTransition(from, to) {
+        return terr
+    }
+    // An active node belongs to its session; an idle or finished one is
+    // free to be taken by a new session
+    checkSession := sessionID != "" && from.Active()
+    if checkSession && holder != sessionID {
+        terr.Conflict = true
+        return terr
+    }
+
+    query := `
+        UPDATE hc_node_status
+        SET current_status = ?,` + set + `
+        WHERE neId = ? AND current_status = ?`
+    params := append([]interface{}{to}, args...)
+    params = append(params, neID, from)
+    if checkSession {
+        query += " AND current_session_id = ?"
+        params = append(params, sessionID)
+    }
+
+    res, err := tx.Exec(query, params...)
+    if err != nil {
+        return err
+    }
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n == 0 {
+        terr.Conflict = true
+        return terr
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO hc_status_transitions (neId, session_id, from_status, to_status)
+        VALUES (?, NULLIF(?, ''), ?, ?)
+    `, neID, sessionID, from, to)
+    if err != nil {
+        return fmt.Errorf("failed to record transition: %w", err)
+    }
+
+    return tx.Commit()
+}
+
 // RecordCompletion records health check completion
 func (m *Manager) RecordCompletion(neID, sessionID string, success bool, duration int, errorMsg string) error {
-    status := StatusCompleted
-    result := "success"
-    if !success {
-        status = StatusFailed
-        result = "failed"
+    if success {
+        return m.recordResult(neID, sessionID, StatusCompleted, "success", true, duration, errorMsg)
     }
+    return m.recordResult(neID, sessionID, StatusFailed, "failed", false, duration, errorMsg)
+}
 
-    _, err := m.db.Exec(`
-        UPDATE hc_node_status
-        SET current_status = ?,
+// RecordTimeout records a health check that ran out of time
+func (m *Manager) RecordTimeout(neID, sessionID string, duration int, errorMsg string) error {
+    return m.recordResult(neID, sessionID, StatusTimeout, "timeout", false, duration, errorMsg)
+}
+
+// RecordCancelled records a health check that was cancelled. It does not
+// count towards the node's check totals or consecutive failures.
+func (m *Manager) RecordCancelled(neID, sessionID string, duration int, errorMsg string) error {
+    return m.transition(neID, sessionID, StatusCancelled, `
+            last_check_completed = NOW(),
+            last_check_duration = ?,
+            last_check_result = 'cancelled',
+            error_message = ?,
+            current_session_id = NULL,
+            current_username = NULL`,
+        duration, errorMsg)
+}
+
+// recordResult moves the node to a final status and updates its counters
+func (m *Manager) recordResult(neID, sessionID string, status Status, result string, success bool, duration int, errorMsg string) error {
+    return m.transition(neID, sessionID, status, `
             last_check_completed = NOW(),
             last_check_duration = ?,
             last_check_result = ?,
@@ -66,9 +248,34 @@ func (m *Manager) RecordCompletion(neID, sessionID string, success bool, duratio
             last_successful_check = CASE WHEN ? THEN NOW() ELSE last_successful_check END,
             error_message = ?,
             current_session_id = NULL,
-            current_username = NULL
+            current_username = NULL`,
+        duration, result, success, success, success, errorMsg)
+}
+
+// ResetSession returns a node to idle if it is still held by sessionID,
+// for checks whose process died before recording completion. It does
+// nothing and reports false if the node has moved on.
+func (m *Manager) ResetSession(neID, sessionID, errorMsg string) (bool, error) {
+    err := m.transition(neID, sessionID, StatusIdle, `
+            current_session_id = NULL,
+            current_username = NULL,
+            error_message = ?`,
+        errorMsg)
+
+    var terr *TransitionError
+    if errors.As(err, &terr) && (terr.Conflict || !terr.From.Active()) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// RecordScore stores the health score of the node's latest check
+func (m *Manager) RecordScore(neID string, score int) error {
+    _, err := m.db.Exec(`
+        UPDATE hc_node_status
+        SET health_score = ?
         WHERE neId = ?
-    `, status, duration, result, success, success, success, errorMsg, neID)
+    `, score, neID)
 
     return err
 }
@@ -89,19 +296,147 @@ func (m *Manager) GetNodeStatus(neID string) (Status, error) {
     return Status(status), nil
 }
 
+// NodeDetails is the full status row of a node
+type NodeDetails struct {
+    NeID                string     `json:"neId"`
+    Status              Status     `json:"status"`
+    SessionID           string     `json:"session_id,omitempty"`
+    Username            string     `json:"username,omitempty"`
+    LastCheckStarted    *time.Time `json:"last_check_started"`
+    LastCheckCompleted  *time.Time `json:"last_check_completed"`
+    LastCheckDuration   *int       `json:"last_check_duration"`
+    LastCheckResult     string     `json:"last_check_result,omitempty"`
+    HealthScore         *int       `json:"health_score"`
+    ErrorMessage        string     `json:"error_message,omitempty"`
+    RetryCount          int        `json:"retry_count"`
+    ConsecutiveFailures int        `json:"consecutive_failures"`
+    LastSuccessfulCheck *time.Time `json:"last_successful_check"`
+    TotalChecks         int        `json:"total_checks"`
+    SuccessfulChecks    int        `json:"successful_checks"`
+    UpdatedAt           *time.Time `json:"updated_at"`
+}
+
+// GetNodeDetails returns the status row of a node. It returns
+// sql.ErrNoRows for unknown nodes.
+func (m *Manager) GetNodeDetails(neID string) (*NodeDetails, error) {
+    d := &NodeDetails{}
+    err := m.db.QueryRow(`
+        SELECT neId, current_status, COALESCE(current_session_id, ''), COALESCE(current_username, ''),
+               last_check_started, last_check_completed, last_check_duration,
+               COALESCE(last_check_result, ''), health_score, COALESCE(error_message, ''),
+               COALESCE(retry_count, 0), COALESCE(consecutive_failures, 0), last_successful_check,
+               COALESCE(total_checks, 0), COALESCE(successful_checks, 0), updated_at
+        FROM hc_node_status
+        WHERE neId = ?
+    `, neID).Scan(
+        &d.NeID,
+        &d.Status,
+        &d.SessionID,
+        &d.Username,
+        &d.LastCheckStarted,
+        &d.LastCheckCompleted,
+        &d.LastCheckDuration,
+        &d.LastCheckResult,
+        &d.HealthScore,
+        &d.ErrorMessage,
+        &d.RetryCount,
+        &d.ConsecutiveFailures,
+        &d.LastSuccessfulCheck,
+        &d.TotalChecks,
+        &d.SuccessfulChecks,
+        &d.UpdatedAt,
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    return d, nil
+}
+
+// CountByStatus returns the number of nodes in each status
+func (m *Manager) CountByStatus() (map[Status]int, error) {
+    rows, err := m.db.Query(`
+        SELECT current_status, COUNT(*)
+        FROM hc_node_status
+        GROUP BY current_status
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    counts := make(map[Status]int)
+    for rows.Next() {
+        var st Status
+        var n int
+        if err := rows.Scan(&st, &n); err != nil {
+            return nil, err
+        }
+        counts[st] = n
+    }
+
+    return counts, rows.Err()
+}
+
 // GetActiveChecks returns count of currently running checks
 func (m *Manager) GetActiveChecks() (int, error) {
     var count int
     err := m.db.QueryRow(`
         SELECT COUNT(*)
         FROM hc_node_status
-        WHERE current_status IN ('queued', 'connecting', 'running', 'polling')
+        WHERE current_status IN ('queued', 'connecting', 'running', 'polling', 'collecting')
     `).Scan(&count)
 
     return count, err
 }
 
-// AddLiveUpdate adds a progress update
+// OverdueCheck is an active check that has run longer than allowed
+type OverdueCheck struct {
+    NeID      string
+    SessionID string
+    Username  string
+    MitoProxy string
+    Status    Status
+    StartedAt time.Time
+
+    // Elapsed is the number of seconds since StartedAt by the database clock
+    Elapsed int
+}
+
+// GetOverdueChecks returns active checks started more than maxWait ago.
+// The start is taken from the session's history row when there is one.
+func (m *Manager) GetOverdueChecks(maxWait time.Duration) ([]*OverdueCheck, error) {
+    rows, err := m.db.Query(`
+        SELECT s.neId, COALESCE(s.current_session_id, ''), COALESCE(s.current_username, ''),
+               COALESCE(h.mito_proxy_used, ''), s.current_status,
+               COALESCE(h.started_at, s.last_check_started, s.updated_at) AS started,
+               TIMESTAMPDIFF(SECOND, COALESCE(h.started_at, s.last_check_started, s.updated_at), NOW())
+        FROM hc_node_status s
+        LEFT JOIN hc_history h ON h.session_id = s.current_session_id
+        WHERE s.current_status IN ('queued', 'connecting', 'running', 'polling', 'collecting')
+          AND COALESCE(h.started_at, s.last_check_started, s.updated_at) < NOW() - INTERVAL ? SECOND
+        ORDER BY started ASC
+    `, int(maxWait.Seconds()))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var checks []*OverdueCheck
+    for rows.Next() {
+        c := &OverdueCheck{}
+        err := rows.Scan(&c.NeID, &c.SessionID, &c.Username, &c.MitoProxy, &c.Status, &c.StartedAt, &c.Elapsed)
+        if err != nil {
+            return nil, err
+        }
+        checks = append(checks, c)
+    }
+
+    return checks, rows.Err()
+}
+
+// AddLiveUpdate adds a progress update. The session's hc_history row
+// must already exist (see history.Recorder.Start).
 func (m *Manager) AddLiveUpdate(sessionID, neID, status, message string, progress int) error {
     _, err := m.db.Exec(`
         INSERT INTO hc_live_updates (session_id, neId, status, message, progress_percentage)
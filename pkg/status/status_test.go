@@ -0,0 +1,122 @@
+package status
+
+import "testing"
+
+var allStatuses = []Status{
+    StatusIdle, StatusQueued, StatusConnecting, StatusRunning, StatusPolling,
+    StatusCollecting, StatusCompleted, StatusFailed, StatusTimeout, StatusCancelled,
+}
+
+func TestCanTransition(t *testing.T) {
+    tests := []struct {
+        from, to Status
+        want     bool
+    }{
+        // The happy path
+        {StatusIdle, StatusQueued, true},
+        {StatusQueued, StatusConnecting, true},
+        {StatusConnecting, StatusRunning, true},
+        {StatusRunning, StatusPolling, true},
+        {StatusPolling, StatusCollecting, true},
+        {StatusCollecting, StatusCompleted, true},
+
+        // Ending early, retrying and dying
+        {StatusQueued, StatusCancelled, true},
+        {StatusConnecting, StatusFailed, true},
+        {StatusRunning, StatusTimeout, true},
+        {StatusCollecting, StatusCancelled, true},
+        {StatusConnecting, StatusQueued, true},
+        {StatusPolling, StatusQueued, true},
+        {StatusRunning, StatusIdle, true},
+        {StatusCollecting, StatusIdle, true},
+
+        // Finished checks are queued again when due
+        {StatusCompleted, StatusQueued, true},
+        {StatusFailed, StatusQueued, true},
+        {StatusTimeout, StatusQueued, true},
+        {StatusCancelled, StatusQueued, true},
+
+        // Skipping steps
+        {StatusIdle, StatusConnecting, false},
+        {StatusIdle, StatusRunning, false},
+        {StatusQueued, StatusRunning, false},
+        {StatusConnecting, StatusCompleted, false},
+        {StatusRunning, StatusCollecting, false},
+
+        // Going backwards
+        {StatusPolling, StatusRunning, false},
+        {StatusCollecting, StatusPolling, false},
+        {StatusCollecting, StatusQueued, false},
+
+        // Leaving or ending a finished check
+        {StatusCompleted, StatusRunning, false},
+        {StatusCompleted, StatusFailed, false},
+        {StatusFailed, StatusCompleted, false},
+        {StatusCancelled, StatusIdle, false},
+        {StatusTimeout, StatusCancelled, false},
+        {StatusIdle, StatusCompleted, false},
+        {StatusIdle, StatusCancelled, false},
+
+        // Staying put and unknown statuses
+        {StatusRunning, StatusRunning, false},
+        {StatusIdle, StatusIdle, false},
+        {Status("paused"), StatusQueued, false},
+        {StatusQueued, Status("paused"), false},
+    }
+
+    for _, tt := range tests {
+        if got := CanTransition(tt.from, tt.to); got != tt.want {
+            t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+        }
+    }
+}
+
+func TestTransitionsCoverEveryStatus(t *testing.T) {
+    for _, s := range allStatuses {
+        if len(transitions[s]) == 0 {
+            t.Errorf("no transitions out of %s", s)
+        }
+        for _, next := range transitions[s] {
+            if next == s {
+                t.Errorf("%s may transition to itself", s)
+            }
+        }
+
+        // Every active check can be ended and abandoned
+        if s.Active() {
+            for _, end := range []Status{StatusFailed, StatusTimeout, StatusCancelled, StatusIdle} {
+                if !CanTransition(s, end) {
+                    t.Errorf("active %s cannot move to %s", s, end)
+                }
+            }
+        }
+    }
+}
+
+func TestActiveFinal(t *testing.T) {
+    tests := []struct {
+        status        Status
+        active, final bool
+    }{
+        {StatusIdle, false, false},
+        {StatusQueued, true, false},
+        {StatusConnecting, true, false},
+        {StatusRunning, true, false},
+        {StatusPolling, true, false},
+        {StatusCollecting, true, false},
+        {StatusCompleted, false, true},
+        {StatusFailed, false, true},
+        {StatusTimeout, false, true},
+        {StatusCancelled, false, true},
+        {Status("paused"), false, false},
+    }
+
+    for _, tt := range tests {
+        if got := tt.status.Active(); got != tt.active {
+            t.Errorf("%s.Active() = %v, want %v", tt.status, got, tt.active)
+        }
+        if got := tt.status.Final(); got != tt.final {
+            t.Errorf("%s.Final() = %v, want %v", tt.status, got, tt.final)
+        }
+    }
+}
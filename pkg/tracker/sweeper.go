@@ -0,0 +1,139 @@
+package tracker
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/status"
+    "health-check-system/pkg/userpool"
+)
+
+// sweepGrace gives a live executor, which enforces the same max wait
+// through its context, time to record the timeout itself
+const sweepGrace = time.Minute
+
+// Sweeper times out checks that stayed active longer than the max wait,
+// e.g. because their process hung or died without a trace in
+// hc_active_sessions
+type Sweeper struct {
+    tracker *Tracker
+    users   *userpool.Pool
+    status  *status.Manager
+    history *history.Recorder
+    maxWait time.Duration
+}
+
+// NewSweeper creates a sweeper for checks active longer than maxWait
+func NewSweeper(tracker *Tracker, users *userpool.Pool, st *status.Manager, hist *history.Recorder, maxWait time.Duration) *Sweeper {
+    return &Sweeper{
+        tracker: tracker,
+        users:   users,
+        status:  st,
+        history: hist,
+        maxWait: maxWait,
+    }
+}
+
+// Sweep moves every overdue check to timeout, finalizes its history and
+// releases its NIAM user. Its proxy connection is released by unregistering
+// the session, so a hung executor that later closes the session does not
+// release it a second time. The node is then picked
+// again by GetNodesToCheck once the recheck interval has passed. It
+// returns the number of checks timed out.
+func (s *Sweeper) Sweep() (int, error) {
+    overdue, err := s.status.GetOverdueChecks(s.maxWait + sweepGrace)
+    if err != nil {
+        return 0, fmt.Errorf("failed to get overdue checks: %w", err)
+    }
+
+    swept := 0
+    for _, c := range overdue {
+        ok, err := s.sweep(c)
+        if err != nil {
+            log.Printf("Failed to time out check %s on %s: %v", c.SessionID, c.NeID, err)
+            continue
+        }
+        if ok {
+            swept++
+        }
+    }
+
+    // Requests older than any check can run belong to sessions that
+    // ended without clearing them
+    if n, err := s.status.ExpireCancelRequests(s.maxWait + sweepGrace); err != nil {
+        log.Printf("Failed to expire cancel requests: %v", err)
+    } else if n > 0 {
+        log.Printf("Expired %d stale cancel requests", n)
+    }
+
+    return swept, nil
+}
+
+// sweep times out one check. It reports false if the check finished or
+// moved on in the meantime.
+func (s *Sweeper) sweep(c *status.OverdueCheck) (bool, error) {
+    duration := c.Elapsed
+    msg := fmt.Sprintf("check exceeded max wait of %s while %s", s.maxWait, c.Status)
+
+    err := s.status.RecordTimeout(c.NeID, c.SessionID, duration, msg)
+    var terr *status.TransitionError
+    if errors.As(err, &terr) && terr.Conflict {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+
+    if c.SessionID != "" {
+        err := s.history.Finish(c.SessionID, history.Result{
+            FinalStatus: string(status.StatusTimeout),
+            Result:      "timeout",
+            Duration:    duration,
+            Error:       msg,
+        })
+        if err != nil {
+            log.Printf("Failed to finish history for %s: %v", c.SessionID, err)
+        }
+
+        if err := s.tracker.Unregister(c.SessionID); err != nil {
+            log.Printf("Failed to unregister session %s: %v", c.SessionID, err)
+        }
+
+        if err := s.status.ClearCancelRequest(c.SessionID); err != nil {
+            log.Printf("Failed to clear cancel request for %s: %v", c.SessionID, err)
+        }
+    }
+
+    if c.Username != "" {
+        if err := s.users.ReleaseUser(c.Username, c.SessionID); err != nil {
+            log.Printf("Failed to release user %s: %v", c.Username, err)
+        }
+    }
+
+    log.Printf("Timed out check %s on %s: %s", c.SessionID, c.NeID, msg)
+    return true, nil
+}
+
+// Run sweeps overdue checks every interval until ctx is done
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            n, err := s.Sweep()
+            if err != nil {
+                log.Printf("Sweeper failed: %v", err)
+            } else if n > 0 {
+                log.Printf("Timed out %d overdue checks", n)
+            }
+        }
+    }
+}
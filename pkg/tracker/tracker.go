@@ -0,0 +1,277 @@
+package tracker
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+
+    "health-check-system/pkg/history"
+    "health-check-system/pkg/status"
+    "health-check-system/pkg/userpool"
+)
+
+// ActiveSession is an open SSH session registered in hc_active_sessions
+type ActiveSession struct {
+    SessionID    string
+    Username     string
+    NeID         string
+    NodeIP       string
+    MitoProxy    string
+    AppServer    string
+    StartedAt    time.Time
+    LastActivity time.Time
+}
+
+// Tracker registers open SSH sessions and their heartbeats
+type Tracker struct {
+    db *sql.DB
+}
+
+// NewTracker creates a new session tracker
+func NewTracker(db *sql.DB) *Tracker {
+    return &Tracker{
+        db: db,
+    }
+}
+
+// Register records an open session
+func (t *Tracker) Register(s *ActiveSession) error {
+    _, err := t.db.Exec(`
+        INSERT INTO hc_active_sessions (session_id, username, neId, node_ip, mito_proxy_used, app_server_used, started_at, last_activity)
+        VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())
+    `, s.SessionID, s.Username, s.NeID, s.NodeIP, s.MitoProxy, s.AppServer)
+
+    if err != nil {
+        return fmt.Errorf("failed to register session %s: %w", s.SessionID, err)
+    }
+    return nil
+}
+
+// Heartbeat marks a session as still alive
+func (t *Tracker) Heartbeat(sessionID string) error {
+    _, err := t.db.Exec(`
+        UPDATE hc_active_sessions
+        SET last_activity = NOW()
+        WHERE session_id = ?
+    `, sessionID)
+
+    return err
+}
+
+// Unregister removes a session once it is closed and gives its connection
+// slot back to its Mito proxy and its load back to its app server. Only the
+// call that removes the row releases them, so the executor, reaper and
+// sweeper may all unregister the same session.
+func (t *Tracker) Unregister(sessionID string) error {
+    tx, err := t.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    var proxyName, serverName string
+    err = tx.QueryRow(`
+        SELECT COALESCE(mito_proxy_used, ''), COALESCE(app_server_used, '')
+        FROM hc_active_sessions
+        WHERE session_id = ?
+        FOR UPDATE
+    `, sessionID).Scan(&proxyName, &serverName)
+    if err == sql.ErrNoRows {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    if _, err := tx.Exec(`
+        DELETE FROM hc_active_sessions
+        WHERE session_id = ?
+    `, sessionID); err != nil {
+        return err
+    }
+
+    if proxyName != "" {
+        if _, err := tx.Exec(`
+            UPDATE hc_mito_proxies
+            SET current_connections = GREATEST(current_connections - 1, 0)
+            WHERE proxy_name = ?
+        `, proxyName); err != nil {
+            return fmt.Errorf("failed to release proxy %s: %w", proxyName, err)
+        }
+    }
+
+    if serverName != "" {
+        if _, err := tx.Exec(`
+            UPDATE hc_app_servers
+            SET current_load = GREATEST(current_load - 1, 0)
+            WHERE server_name = ?
+        `, serverName); err != nil {
+            return fmt.Errorf("failed to release app server %s: %w", serverName, err)
+        }
+    }
+
+    return tx.Commit()
+}
+
+// KeepAlive sends heartbeats for a session until ctx is done
+func (t *Tracker) KeepAlive(ctx context.Context, sessionID string, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := t.Heartbeat(sessionID); err != nil {
+                log.Printf("Failed to send heartbeat for %s: %v", sessionID, err)
+            }
+        }
+    }
+}
+
+// GetActiveSessions returns all registered sessions
+func (t *Tracker) GetActiveSessions() ([]*ActiveSession, error) {
+    return t.query(`
+        SELECT session_id, username, neId, COALESCE(node_ip, ''), COALESCE(mito_proxy_used, ''),
+               COALESCE(app_server_used, ''), started_at, last_activity
+        FROM hc_active_sessions
+        ORDER BY started_at ASC
+    `)
+}
+
+// GetStaleSessions returns sessions without a heartbeat for longer than staleAfter
+func (t *Tracker) GetStaleSessions(staleAfter time.Duration) ([]*ActiveSession, error) {
+    return t.query(`
+        SELECT session_id, username, neId, COALESCE(node_ip, ''), COALESCE(mito_proxy_used, ''),
+               COALESCE(app_server_used, ''), started_at, last_activity
+        FROM hc_active_sessions
+        WHERE last_activity < NOW() - INTERVAL ? SECOND
+        ORDER BY last_activity ASC
+    `, int(staleAfter.Seconds()))
+}
+
+func (t *Tracker) query(query string, args ...interface{}) ([]*ActiveSession, error) {
+    rows, err := t.db.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var sessions []*ActiveSession
+    for rows.Next() {
+        s := &ActiveSession{}
+        err := rows.Scan(
+            &s.SessionID,
+            &s.Username,
+            &s.NeID,
+            &s.NodeIP,
+            &s.MitoProxy,
+            &s.AppServer,
+            &s.StartedAt,
+            &s.LastActivity,
+        )
+        if err != nil {
+            return nil, err
+        }
+        sessions = append(sessions, s)
+    }
+
+    return sessions, rows.Err()
+}
+
+// Reaper cleans up sessions whose process stopped sending heartbeats
+type Reaper struct {
+    tracker    *Tracker
+    users      *userpool.Pool
+    status     *status.Manager
+    history    *history.Recorder
+    staleAfter time.Duration
+}
+
+// NewReaper creates a reaper for sessions idle longer than staleAfter
+func NewReaper(tracker *Tracker, users *userpool.Pool, st *status.Manager, hist *history.Recorder, staleAfter time.Duration) *Reaper {
+    return &Reaper{
+        tracker:    tracker,
+        users:      users,
+        status:     st,
+        history:    hist,
+        staleAfter: staleAfter,
+    }
+}
+
+// Reap releases the NIAM user slot, proxy connection and app server load
+// of every stale session, returns its node to idle and finalizes its history. It returns
+// the number reaped.
+func (r *Reaper) Reap() (int, error) {
+    stale, err := r.tracker.GetStaleSessions(r.staleAfter)
+    if err != nil {
+        return 0, fmt.Errorf("failed to get stale sessions: %w", err)
+    }
+
+    reaped := 0
+    for _, s := range stale {
+        if err := r.reap(s); err != nil {
+            log.Printf("Failed to reap session %s: %v", s.SessionID, err)
+            continue
+        }
+        reaped++
+    }
+
+    return reaped, nil
+}
+
+func (r *Reaper) reap(s *ActiveSession) error {
+    msg := fmt.Sprintf("session abandoned, no heartbeat since %s", s.LastActivity.Format(time.RFC3339))
+
+    reset, err := r.status.ResetSession(s.NeID, s.SessionID, msg)
+    if err != nil {
+        return fmt.Errorf("failed to reset %s: %w", s.NeID, err)
+    }
+
+    // If the node moved on, its check was finished by the executor or the
+    // sweeper, so only the leftover lease and registration are cleaned up
+    if reset {
+        err := r.history.Finish(s.SessionID, history.Result{
+            FinalStatus: string(status.StatusFailed),
+            Result:      "abandoned",
+            Duration:    int(s.LastActivity.Sub(s.StartedAt).Seconds()),
+            Error:       msg,
+        })
+        if err != nil {
+            return err
+        }
+    }
+
+    if err := r.users.ReleaseUser(s.Username, s.SessionID); err != nil {
+        return fmt.Errorf("failed to release user %s: %w", s.Username, err)
+    }
+
+    if err := r.status.ClearCancelRequest(s.SessionID); err != nil {
+        log.Printf("Failed to clear cancel request for %s: %v", s.SessionID, err)
+    }
+
+    return r.tracker.Unregister(s.SessionID)
+}
+
+// Run reaps stale sessions every interval until ctx is done
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            n, err := r.Reap()
+            if err != nil {
+                log.Printf("Reaper failed: %v", err)
+            } else if n > 0 {
+                log.Printf("Reaped %d stale sessions", n)
+            }
+        }
+    }
+}
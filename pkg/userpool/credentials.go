@@ -0,0 +1,152 @@
+package userpool
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+)
+
+// ExpiringUser is a user whose credentials expire soon
+type ExpiringUser struct {
+    Username   string
+    ExpiryDate time.Time
+    DaysLeft   int
+}
+
+// ExpireUsers marks users whose expiry_date has passed as expired and
+// returns how many were marked
+func (p *Pool) ExpireUsers() (int, error) {
+    res, err := p.db.Exec(`
+        UPDATE hc_niam_users
+        SET is_expired = TRUE
+        WHERE is_expired = FALSE
+          AND expiry_date < CURDATE()
+    `)
+    if err != nil {
+        return 0, fmt.Errorf("failed to expire users: %w", err)
+    }
+
+    n, err := res.RowsAffected()
+    return int(n), err
+}
+
+// GetExpiringUsers returns users whose credentials expire within the next
+// days days
+func (p *Pool) GetExpiringUsers(days int) ([]*ExpiringUser, error) {
+    rows, err := p.db.Query(`
+        SELECT user, expiry_date, DATEDIFF(expiry_date, CURDATE())
+        FROM hc_niam_users
+        WHERE is_expired = FALSE
+          AND expiry_date BETWEEN CURDATE() AND CURDATE() + INTERVAL ? DAY
+        ORDER BY expiry_date ASC
+    `, days)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var users []*ExpiringUser
+    for rows.Next() {
+        u := &ExpiringUser{}
+        if err := rows.Scan(&u.Username, &u.ExpiryDate, &u.DaysLeft); err != nil {
+            return nil, err
+        }
+        users = append(users, u)
+    }
+
+    return users, rows.Err()
+}
+
+// CheckExpiry expires users past their expiry_date and logs a warning for
+// every user expiring within the configured warning window
+func (p *Pool) CheckExpiry() error {
+    expired, err := p.ExpireUsers()
+    if err != nil {
+        return err
+    }
+    if expired > 0 {
+        log.Printf("Marked %d NIAM users as expired", expired)
+    }
+
+    expiring, err := p.GetExpiringUsers(p.expiryWarning)
+    if err != nil {
+        return fmt.Errorf("failed to get expiring users: %w", err)
+    }
+    for _, u := range expiring {
+        log.Printf("WARNING: NIAM user %s expires on %s (%d days left)",
+            u.Username, u.ExpiryDate.Format("2006-01-02"), u.DaysLeft)
+    }
+
+    return nil
+}
+
+// RunExpiryCheck checks credential expiry now and then every interval
+// until ctx is done
+func (p *Pool) RunExpiryCheck(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        if err := p.CheckExpiry(); err != nil {
+            log.Printf("Expiry check failed: %v", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// RecordAuthFailure counts an authentication failure for a user. Once
+// the configured number of consecutive failures is reached the user is
+// excluded from selection for the lockout duration, so the NIAM server
+// does not lock the account. The count starts over with the lockout, so
+// after it expires the user again gets the full number of attempts.
+func (p *Pool) RecordAuthFailure(username string) error {
+    // MySQL applies the SET assignments in order, so locked_until is
+    // decided on the failure_count before it is reset
+    _, err := p.db.Exec(`
+        UPDATE hc_niam_users
+        SET locked_until = CASE
+                WHEN failure_count + 1 >= ? THEN NOW() + INTERVAL ? SECOND
+                ELSE locked_until
+            END,
+            failure_count = CASE
+                WHEN failure_count + 1 >= ? THEN 0
+                ELSE failure_count + 1
+            END
+        WHERE user = ?
+    `, p.maxAuthFailures, int(p.lockout.Seconds()), p.maxAuthFailures, username)
+    if err != nil {
+        return fmt.Errorf("failed to record auth failure for %s: %w", username, err)
+    }
+
+    var locked bool
+    err = p.db.QueryRow(`
+        SELECT failure_count = 0 AND COALESCE(locked_until > NOW(), FALSE)
+        FROM hc_niam_users
+        WHERE user = ?
+    `, username).Scan(&locked)
+    if err == nil && locked {
+        log.Printf("NIAM user %s locked out for %s after %d authentication failures",
+            username, p.lockout, p.maxAuthFailures)
+    }
+
+    return nil
+}
+
+// RecordAuthSuccess resets the failure count and lockout of a user
+func (p *Pool) RecordAuthSuccess(username string) error {
+    _, err := p.db.Exec(`
+        UPDATE hc_niam_users
+        SET failure_count = 0,
+            locked_until = NULL
+        WHERE user = ?
+          AND (failure_count > 0 OR locked_until IS NOT NULL)
+    `, username)
+
+    return err
+}
@@ -0,0 +1,153 @@
+package userpool
+
+import (
+    "encoding/json"
+    "fmt"
+    "time"
+)
+
+// PoolStatus is a snapshot of the NIAM user pool. Capacities use each
+// user's max_sessions capped by MaxSessionsPerUser; available capacity
+// only counts users that can currently be selected.
+type PoolStatus struct {
+    TotalUsers        int `json:"total_users"`
+    ActiveUsers       int `json:"active_users"`
+    ExpiredUsers      int `json:"expired_users"`
+    LockedUsers       int `json:"locked_users"`
+    TotalCapacity     int `json:"total_capacity"`
+    UsedCapacity      int `json:"used_capacity"`
+    AvailableCapacity int `json:"available_capacity"`
+
+    Users   []*UserStatus   `json:"users"`
+    Servers []*ServerStatus `json:"niam_servers"`
+}
+
+// UserStatus is the state of one NIAM user
+type UserStatus struct {
+    Username         string     `json:"username"`
+    NiamIP           string     `json:"niam_ip"`
+    LoginStatus      string     `json:"login_status"`
+    CurrentSessions  int        `json:"current_sessions"`
+    MaxSessions      int        `json:"max_sessions"`
+    ActiveSessionIDs []string   `json:"active_session_ids"`
+    IsExpired        bool       `json:"is_expired"`
+    ExpiryDate       *time.Time `json:"expiry_date"`
+    FailureCount     int        `json:"failure_count"`
+    LockedUntil      *time.Time `json:"locked_until"`
+    LastUsedAt       *time.Time `json:"last_used_at"`
+
+    // Available is whether the user can be selected right now
+    Available bool `json:"available"`
+}
+
+// ServerStatus is the capacity of the users on one NIAM server
+type ServerStatus struct {
+    NiamIP            string `json:"niam_ip"`
+    Users             int    `json:"users"`
+    ActiveUsers       int    `json:"active_users"`
+    TotalCapacity     int    `json:"total_capacity"`
+    UsedCapacity      int    `json:"used_capacity"`
+    AvailableCapacity int    `json:"available_capacity"`
+}
+
+// GetPoolStatus returns the current pool status with a breakdown per user
+// and per NIAM server
+func (p *Pool) GetPoolStatus() (*PoolStatus, error) {
+    rows, err := p.db.Query(`
+        SELECT user, niam_ip, COALESCE(login_status, ''),
+               COALESCE(current_sessions, 0), COALESCE(max_sessions, 0),
+               active_session_ids, COALESCE(is_expired, FALSE), expiry_date,
+               COALESCE(failure_count, 0), locked_until, last_used_at,
+               COALESCE(expiry_date < CURDATE(), FALSE),
+               COALESCE(locked_until > NOW(), FALSE)
+        FROM hc_niam_users
+        ORDER BY niam_ip ASC, user ASC
+    `)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    status := &PoolStatus{
+        Users:   []*UserStatus{},
+        Servers: []*ServerStatus{},
+    }
+    servers := make(map[string]*ServerStatus)
+
+    for rows.Next() {
+        u := &UserStatus{}
+        var sessionIDs []byte
+        var pastExpiry, locked bool
+        err := rows.Scan(
+            &u.Username,
+            &u.NiamIP,
+            &u.LoginStatus,
+            &u.CurrentSessions,
+            &u.MaxSessions,
+            &sessionIDs,
+            &u.IsExpired,
+            &u.ExpiryDate,
+            &u.FailureCount,
+            &u.LockedUntil,
+            &u.LastUsedAt,
+            &pastExpiry,
+            &locked,
+        )
+        if err != nil {
+            return nil, err
+        }
+
+        u.ActiveSessionIDs = []string{}
+        if len(sessionIDs) > 0 {
+            if err := json.Unmarshal(sessionIDs, &u.ActiveSessionIDs); err != nil {
+                return nil, fmt.Errorf("invalid active_session_ids for %s: %w", u.Username, err)
+            }
+        }
+
+        if p.maxSessions > 0 && u.MaxSessions > p.maxSessions {
+            u.MaxSessions = p.maxSessions
+        }
+
+        expired := u.IsExpired || pastExpiry
+        active := u.LoginStatus == "Yes"
+        u.Available = active && !expired && !locked && u.CurrentSessions < u.MaxSessions
+
+        srv, ok := servers[u.NiamIP]
+        if !ok {
+            srv = &ServerStatus{NiamIP: u.NiamIP}
+            servers[u.NiamIP] = srv
+            status.Servers = append(status.Servers, srv)
+        }
+
+        free := 0
+        if active && !expired && !locked && u.MaxSessions > u.CurrentSessions {
+            free = u.MaxSessions - u.CurrentSessions
+        }
+
+        status.TotalUsers++
+        status.TotalCapacity += u.MaxSessions
+        status.UsedCapacity += u.CurrentSessions
+        status.AvailableCapacity += free
+        srv.Users++
+        srv.TotalCapacity += u.MaxSessions
+        srv.UsedCapacity += u.CurrentSessions
+        srv.AvailableCapacity += free
+        if active {
+            status.ActiveUsers++
+            srv.ActiveUsers++
+        }
+        if expired {
+            status.ExpiredUsers++
+        }
+        if locked {
+            status.LockedUsers++
+        }
+
+        status.Users = append(status.Users, u)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    return status, nil
+}
@@ -1,63 +1,181 @@
 package userpool
 
 import (
+    "context"
     "database/sql"
+    "errors"
     "fmt"
+    "log"
     "sync"
     "time"
 )
 
+// ErrLeaseLost is returned when a session's lease no longer exists, e.g.
+// because it expired and was reclaimed
+var ErrLeaseLost = errors.New("NIAM lease lost")
+
 // User represents a NIAM user
 type User struct {
     Username       string
-    Password       string
+    Password       string // may be encrypted, see pkg/secret
     NiamIP         string
     NiamPort       string
     CurrentSessions int
     MaxSessions    int
 }
 
+// Config holds user pool settings
+type Config struct {
+    // MaxSessionsPerUser caps max_sessions of every user when positive
+    MaxSessionsPerUser int
+    MaxWaitTime        time.Duration
+
+    // CheckInterval is how often a waiter rechecks the database for
+    // sessions released by other processes
+    CheckInterval time.Duration
+
+    // LeaseTTL is how long a session slot is held without renewal before
+    // it is reclaimed
+    LeaseTTL time.Duration
+
+    // ExpiryWarningDays is how far ahead expiring credentials are logged
+    ExpiryWarningDays int
+
+    // MaxAuthFailures consecutive authentication failures lock a user out
+    // of selection for LockoutDuration
+    MaxAuthFailures int
+    LockoutDuration time.Duration
+}
+
 // Pool manages NIAM user pool
 type Pool struct {
     db              *sql.DB
     mu              sync.Mutex
+    maxSessions     int
     maxWaitTime     time.Duration
     checkInterval   time.Duration
+    leaseTTL        time.Duration
+    expiryWarning   int
+    maxAuthFailures int
+    lockout         time.Duration
+
+    // waiters are served in arrival order, guarded by mu
+    waiters []*waiter
+}
+
+// waiter is an AcquireUser call queued for a free session
+type waiter struct {
+    wake chan struct{}
 }
 
 // NewPool creates a new user pool
-func NewPool(db *sql.DB) *Pool {
+func NewPool(db *sql.DB, cfg Config) *Pool {
+    if cfg.MaxWaitTime <= 0 {
+        cfg.MaxWaitTime = 5 * time.Minute
+    }
+    if cfg.CheckInterval <= 0 {
+        cfg.CheckInterval = 2 * time.Second
+    }
+    if cfg.LeaseTTL <= 0 {
+        cfg.LeaseTTL = 2 * time.Minute
+    }
+    if cfg.ExpiryWarningDays <= 0 {
+        cfg.ExpiryWarningDays = 7
+    }
+    if cfg.MaxAuthFailures <= 0 {
+        cfg.MaxAuthFailures = 3
+    }
+    if cfg.LockoutDuration <= 0 {
+        cfg.LockoutDuration = 30 * time.Minute
+    }
+
     return &Pool{
-        db:            db,
-        maxWaitTime:   5 * time.Minute,
-        checkInterval: 2 * time.Second,
+        db:              db,
+        maxSessions:     cfg.MaxSessionsPerUser,
+        maxWaitTime:     cfg.MaxWaitTime,
+        checkInterval:   cfg.CheckInterval,
+        leaseTTL:        cfg.LeaseTTL,
+        expiryWarning:   cfg.ExpiryWarningDays,
+        maxAuthFailures: cfg.MaxAuthFailures,
+        lockout:         cfg.LockoutDuration,
     }
 }
 
-// AcquireUser gets an available user from the pool
-func (p *Pool) AcquireUser(sessionID string) (*User, error) {
-    timeout := time.After(p.maxWaitTime)
+// AcquireUser gets an available user from the pool, waiting up to the
+// configured max wait time or until ctx is done. Waiters are served in
+// arrival order and are woken as soon as ReleaseUser frees a session.
+func (p *Pool) AcquireUser(ctx context.Context, sessionID string) (*User, error) {
+    ctx, cancel := context.WithTimeout(ctx, p.maxWaitTime)
+    defer cancel()
+
+    p.mu.Lock()
+    if len(p.waiters) == 0 {
+        user, err := p.tryAcquireUser(sessionID)
+        if err == nil {
+            p.mu.Unlock()
+            return user, nil
+        }
+    }
+    w := &waiter{wake: make(chan struct{}, 1)}
+    p.waiters = append(p.waiters, w)
+    p.mu.Unlock()
+
     ticker := time.NewTicker(p.checkInterval)
     defer ticker.Stop()
 
     for {
         select {
-        case <-timeout:
-            return nil, fmt.Errorf("timeout waiting for available user")
+        case <-ctx.Done():
+            p.mu.Lock()
+            p.removeWaiter(w)
+            p.mu.Unlock()
+            return nil, fmt.Errorf("timeout waiting for available user: %w", ctx.Err())
+        case <-w.wake:
         case <-ticker.C:
-            user, err := p.tryAcquireUser(sessionID)
-            if err == nil {
-                return user, nil
-            }
+        }
+
+        p.mu.Lock()
+        if p.waiters[0] != w {
+            p.mu.Unlock()
+            continue
+        }
+        user, err := p.tryAcquireUser(sessionID)
+        if err == nil {
+            p.removeWaiter(w)
+        }
+        p.mu.Unlock()
+
+        if err == nil {
+            return user, nil
         }
     }
 }
 
-// tryAcquireUser attempts to acquire a user
-func (p *Pool) tryAcquireUser(sessionID string) (*User, error) {
-    p.mu.Lock()
-    defer p.mu.Unlock()
+// removeWaiter drops w from the queue and wakes the new head, which may
+// fit in capacity w was woken for. The caller must hold p.mu.
+func (p *Pool) removeWaiter(w *waiter) {
+    for i, other := range p.waiters {
+        if other == w {
+            p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+            break
+        }
+    }
+    p.wakeHead()
+}
+
+// wakeHead wakes the first waiter. The caller must hold p.mu.
+func (p *Pool) wakeHead() {
+    if len(p.waiters) == 0 {
+        return
+    }
+    select {
+    case p.waiters[0].wake <- struct{}{}:
+    default:
+    }
+}
 
+// tryAcquireUser attempts to acquire a user. The caller must hold p.mu.
+func (p *Pool) tryAcquireUser(sessionID string) (*User, error) {
     tx, err := p.db.Begin()
     if err != nil {
         return nil, err
@@ -70,14 +188,16 @@ func (p *Pool) tryAcquireUser(sessionID string) (*User, error) {
         FROM hc_niam_users
         WHERE login_status = 'Yes'
           AND is_expired = FALSE
-          AND current_sessions < max_sessions
+          AND (expiry_date IS NULL OR expiry_date >= CURDATE())
+          AND (locked_until IS NULL OR locked_until <= NOW())
+          AND current_sessions < CASE WHEN ? > 0 THEN LEAST(max_sessions, ?) ELSE max_sessions END
         ORDER BY current_sessions ASC, last_used_at ASC
         LIMIT 1
         FOR UPDATE
     `
 
     user := &User{}
-    err = tx.QueryRow(query).Scan(
+    err = tx.QueryRow(query, p.maxSessions, p.maxSessions).Scan(
         &user.Username,
         &user.Password,
         &user.NiamIP,
@@ -106,6 +226,14 @@ func (p *Pool) tryAcquireUser(sessionID string) (*User, error) {
         return nil, err
     }
 
+    _, err = tx.Exec(`
+        INSERT INTO hc_niam_leases (session_id, username, acquired_at, renewed_at, expires_at)
+        VALUES (?, ?, NOW(), NOW(), NOW() + INTERVAL ? SECOND)
+    `, sessionID, user.Username, int(p.leaseTTL.Seconds()))
+    if err != nil {
+        return nil, fmt.Errorf("failed to create lease: %w", err)
+    }
+
     if err := tx.Commit(); err != nil {
         return nil, err
     }
@@ -113,46 +241,174 @@ func (p *Pool) tryAcquireUser(sessionID string) (*User, error) {
     return user, nil
 }
 
-// ReleaseUser releases a user back to the pool
+// ReleaseUser releases a user back to the pool. Releasing a session
+// whose lease is already gone is a no-op.
 func (p *Pool) ReleaseUser(username, sessionID string) error {
     p.mu.Lock()
     defer p.mu.Unlock()
 
-    _, err := p.db.Exec(`
+    tx, err := p.db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec(`
+        DELETE FROM hc_niam_leases
+        WHERE session_id = ?
+    `, sessionID)
+    if err != nil {
+        return err
+    }
+
+    // current_sessions and active_session_ids are derived from the leases
+    _, err = tx.Exec(`
         UPDATE hc_niam_users
-        SET current_sessions = GREATEST(current_sessions - 1, 0),
-            active_session_ids = JSON_REMOVE(
-                active_session_ids,
-                JSON_UNQUOTE(JSON_SEARCH(active_session_ids, 'one', ?))
+        SET current_sessions = (SELECT COUNT(*) FROM hc_niam_leases WHERE username = ?),
+            active_session_ids = COALESCE(
+                (SELECT JSON_ARRAYAGG(session_id) FROM hc_niam_leases WHERE username = ?),
+                JSON_ARRAY()
             )
         WHERE user = ?
-    `, sessionID, username)
+    `, username, username, username)
+    if err != nil {
+        return err
+    }
 
-    return err
+    if err := tx.Commit(); err != nil {
+        return err
+    }
+
+    p.wakeHead()
+    return nil
 }
 
-// GetPoolStatus returns current pool status
-func (p *Pool) GetPoolStatus() (map[string]interface{}, error) {
-    var totalUsers, activeUsers, totalCapacity, usedCapacity int
+// RenewLease extends the lease of a session by the lease TTL. It returns
+// ErrLeaseLost if the session has no lease any more.
+func (p *Pool) RenewLease(sessionID string) error {
+    res, err := p.db.Exec(`
+        UPDATE hc_niam_leases
+        SET renewed_at = NOW(),
+            expires_at = NOW() + INTERVAL ? SECOND
+        WHERE session_id = ?
+    `, int(p.leaseTTL.Seconds()), sessionID)
+    if err != nil {
+        return err
+    }
 
-    err := p.db.QueryRow(`
-        SELECT 
-            COUNT(*) as total_users,
-            SUM(CASE WHEN login_status='Yes' THEN 1 ELSE 0 END) as active_users,
-            SUM(max_sessions) as total_capacity,
-            SUM(current_sessions) as used_capacity
-        FROM hc_niam_users
-    `).Scan(&totalUsers, &activeUsers, &totalCapacity, &usedCapacity)
+    n, err := res.RowsAffected()
+    if err != nil {
+        return err
+    }
+    if n > 0 {
+        return nil
+    }
 
+    // MySQL counts changed rows only, so a renewal within the same second
+    // as the last one affects none
+    var exists bool
+    err = p.db.QueryRow(`
+        SELECT COUNT(*) > 0 FROM hc_niam_leases WHERE session_id = ?
+    `, sessionID).Scan(&exists)
     if err != nil {
-        return nil, err
+        return err
+    }
+    if !exists {
+        return fmt.Errorf("%w for session %s", ErrLeaseLost, sessionID)
+    }
+    return nil
+}
+
+// KeepAlive renews the lease of a session until ctx is done or the lease
+// is lost, in which case it returns ErrLeaseLost
+func (p *Pool) KeepAlive(ctx context.Context, sessionID string, interval time.Duration) error {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-ticker.C:
+            err := p.RenewLease(sessionID)
+            if errors.Is(err, ErrLeaseLost) {
+                return err
+            }
+            if err != nil {
+                log.Printf("Failed to renew lease for %s: %v", sessionID, err)
+            }
+        }
     }
+}
+
+// ReclaimExpired deletes leases that were not renewed in time, e.g. after
+// a crash, and recomputes current_sessions of every user whose count no
+// longer matches its leases. It returns the number of leases reclaimed.
+func (p *Pool) ReclaimExpired() (int, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
 
-    return map[string]interface{}{
-        "total_users":      totalUsers,
-        "active_users":     activeUsers,
-        "total_capacity":   totalCapacity,
-        "used_capacity":    usedCapacity,
-        "available_capacity": totalCapacity - usedCapacity,
-    }, nil
+    tx, err := p.db.Begin()
+    if err != nil {
+        return 0, err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.Exec(`
+        DELETE FROM hc_niam_leases
+        WHERE expires_at < NOW()
+    `)
+    if err != nil {
+        return 0, fmt.Errorf("failed to delete expired leases: %w", err)
+    }
+    expired, err := res.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+
+    res, err = tx.Exec(`
+        UPDATE hc_niam_users u
+        SET u.current_sessions = (SELECT COUNT(*) FROM hc_niam_leases l WHERE l.username = u.user),
+            u.active_session_ids = COALESCE(
+                (SELECT JSON_ARRAYAGG(l.session_id) FROM hc_niam_leases l WHERE l.username = u.user),
+                JSON_ARRAY()
+            )
+        WHERE u.current_sessions <> (SELECT COUNT(*) FROM hc_niam_leases l WHERE l.username = u.user)
+    `)
+    if err != nil {
+        return 0, fmt.Errorf("failed to recompute sessions: %w", err)
+    }
+    fixed, err := res.RowsAffected()
+    if err != nil {
+        return 0, err
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, err
+    }
+
+    if expired > 0 || fixed > 0 {
+        p.wakeHead()
+    }
+    return int(expired), nil
+}
+
+// RunReclaimer reclaims expired leases every interval until ctx is done
+func (p *Pool) RunReclaimer(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            n, err := p.ReclaimExpired()
+            if err != nil {
+                log.Printf("Lease reclaim failed: %v", err)
+            } else if n > 0 {
+                log.Printf("Reclaimed %d expired NIAM leases", n)
+            }
+        }
+    }
 }
@@ -14,7 +14,7 @@ DROP TABLE IF EXISTS hc_niam_users;
 CREATE TABLE hc_niam_users (
     id INT PRIMARY KEY AUTO_INCREMENT,
     user VARCHAR(245) UNIQUE NOT NULL,
-    passwd VARCHAR(245) NOT NULL,
+    passwd VARCHAR(512) NOT NULL,
     niam_ip VARCHAR(245) NOT NULL,
     niam_port VARCHAR(45) NOT NULL,
     login_status VARCHAR(45) DEFAULT 'Yes',
@@ -26,6 +26,7 @@ CREATE TABLE hc_niam_users (
     total_usage_count INT DEFAULT 0,
     last_used_at DATETIME,
     failure_count INT DEFAULT 0,
+    locked_until DATETIME,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX idx_user (user),
@@ -108,6 +109,9 @@ CREATE TABLE hc_history (
     health_score INT,
     metrics JSON,
     error_message TEXT,
+    retry_count INT DEFAULT 0,
+    cancel_reason TEXT,
+    cancelled_by VARCHAR(100),
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     INDEX idx_neId (neId),
     INDEX idx_started (started_at),
@@ -143,6 +147,7 @@ CREATE TABLE hc_active_sessions (
     neId VARCHAR(245) NOT NULL,
     node_ip VARCHAR(50),
     mito_proxy_used VARCHAR(100),
+    app_server_used VARCHAR(100),
     started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     last_activity DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
     INDEX idx_username (username),
@@ -162,6 +167,9 @@ CREATE TABLE hc_mito_proxies (
     proxy_port INT DEFAULT 22,
     proxy_user VARCHAR(50) NOT NULL,
     is_active BOOLEAN DEFAULT TRUE,
+    -- Set while the proxy is inactive because of failed probes; only
+    -- these proxies are reactivated by the prober
+    deactivated_by_probe BOOLEAN DEFAULT FALSE,
     is_primary BOOLEAN DEFAULT FALSE,
     priority INT DEFAULT 1,
     current_connections INT DEFAULT 0,
@@ -201,6 +209,53 @@ CREATE TABLE hc_app_servers (
     INDEX idx_active (is_active)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+-- ============================================
+-- TABLE 9: hc_niam_leases
+-- NIAM session slots held by running checks
+-- ============================================
+DROP TABLE IF EXISTS hc_niam_leases;
+CREATE TABLE hc_niam_leases (
+    session_id VARCHAR(100) PRIMARY KEY,
+    username VARCHAR(245) NOT NULL,
+    acquired_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    renewed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_username (username),
+    INDEX idx_expires (expires_at),
+    FOREIGN KEY (username) REFERENCES hc_niam_users(user) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- ============================================
+-- TABLE 10: hc_status_transitions
+-- Audit trail of node status changes
+-- ============================================
+DROP TABLE IF EXISTS hc_status_transitions;
+CREATE TABLE hc_status_transitions (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    neId VARCHAR(245) NOT NULL,
+    session_id VARCHAR(100),
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_neid (neId, created_at),
+    INDEX idx_session (session_id),
+    FOREIGN KEY (neId) REFERENCES hc_nodes(neId) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- ============================================
+-- TABLE 11: hc_cancel_requests
+-- Cancellations waiting for the process running the check
+-- ============================================
+DROP TABLE IF EXISTS hc_cancel_requests;
+CREATE TABLE hc_cancel_requests (
+    session_id VARCHAR(100) PRIMARY KEY,
+    neId VARCHAR(245) NOT NULL,
+    reason TEXT,
+    requested_by VARCHAR(100) NOT NULL,
+    requested_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (neId) REFERENCES hc_nodes(neId) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 SET FOREIGN_KEY_CHECKS=1;
 
 -- ============================================
@@ -251,7 +306,10 @@ UNION ALL SELECT 'hc_history', COUNT(*) FROM hc_history
 UNION ALL SELECT 'hc_live_updates', COUNT(*) FROM hc_live_updates
 UNION ALL SELECT 'hc_active_sessions', COUNT(*) FROM hc_active_sessions
 UNION ALL SELECT 'hc_mito_proxies', COUNT(*) FROM hc_mito_proxies
-UNION ALL SELECT 'hc_app_servers', COUNT(*) FROM hc_app_servers;
+UNION ALL SELECT 'hc_app_servers', COUNT(*) FROM hc_app_servers
+UNION ALL SELECT 'hc_niam_leases', COUNT(*) FROM hc_niam_leases
+UNION ALL SELECT 'hc_status_transitions', COUNT(*) FROM hc_status_transitions
+UNION ALL SELECT 'hc_cancel_requests', COUNT(*) FROM hc_cancel_requests;
 
 SELECT '' as '';
 SELECT 'Mito Proxies:' as Info;